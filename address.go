@@ -0,0 +1,160 @@
+package main
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Default ports of the controllers
+const (
+	DefaultAPIPort = 4343
+	DefaultSSHPort = 22
+)
+
+// Endpoints are the addresses (host:port) to reach a controller
+type Endpoints struct {
+	API string
+	SSH string
+	// Server name expected in the API certificate, if not the API host
+	ServerName string
+	// SHA-256 fingerprint of the API certificate, if pinned
+	Pin string
+}
+
+// Addresses resolves the endpoints of each controller,
+// with overrides for controllers behind NAT or port mappings
+type Addresses struct {
+	apiPort   string
+	sshPort   string
+	overrides map[string]Endpoints
+	byAPI     map[string]Endpoints
+}
+
+// NewAddresses builds the address table. Each override has the format
+// "<controller>,api=<host:port>,ssh=<host:port>", where either host or
+// port can be omitted (e.g. "10.0.0.1,api=:14343,ssh=nat.example.com").
+// Overrides can also set "servername=<name>" and "pin=<sha256>"
+// for the API certificate.
+func NewAddresses(apiPort, sshPort int, overrides []string) (*Addresses, error) {
+	a := &Addresses{
+		apiPort:   strconv.Itoa(apiPort),
+		sshPort:   strconv.Itoa(sshPort),
+		overrides: make(map[string]Endpoints),
+		byAPI:     make(map[string]Endpoints),
+	}
+	for _, override := range overrides {
+		parts := SplitNonEmpty(override, ",")
+		if len(parts) < 2 {
+			return nil, errors.Errorf("Address override '%s' must be '<controller>,api=<host:port>,ssh=<host:port>'", override)
+		}
+		md := unbracket(parts[0])
+		endpoints := a.defaults(md)
+		for _, part := range parts[1:] {
+			kv := strings.SplitN(part, "=", 2)
+			if len(kv) != 2 {
+				return nil, errors.Errorf("Expected key=value in address override '%s', got '%s'", override, part)
+			}
+			switch strings.TrimSpace(kv[0]) {
+			case "api":
+				endpoints.API = withDefaults(strings.TrimSpace(kv[1]), md, a.apiPort)
+			case "ssh":
+				endpoints.SSH = withDefaults(strings.TrimSpace(kv[1]), md, a.sshPort)
+			case "servername":
+				endpoints.ServerName = strings.TrimSpace(kv[1])
+			case "pin":
+				pin, err := normalizePin(kv[1])
+				if err != nil {
+					return nil, errors.Wrapf(err, "Wrong pin in address override '%s'", override)
+				}
+				endpoints.Pin = pin
+			default:
+				return nil, errors.Errorf("Unknown key '%s' in address override '%s'", kv[0], override)
+			}
+		}
+		a.overrides[md] = endpoints
+		a.byAPI[endpoints.API] = endpoints
+	}
+	return a, nil
+}
+
+// Lookup returns the endpoints of the controller. The controller can be
+// a host name, an IPv4 or IPv6 address, with an optional API port
+// (e.g. "mm.example.com:14343" or "[2001:db8::1]:14343").
+func (a *Addresses) Lookup(md string) Endpoints {
+	if a == nil {
+		a = &Addresses{apiPort: strconv.Itoa(DefaultAPIPort), sshPort: strconv.Itoa(DefaultSSHPort)}
+	}
+	host, port := SplitTarget(md)
+	if endpoints, ok := a.overrides[host]; ok {
+		return endpoints
+	}
+	endpoints := a.defaults(host)
+	if port != "" {
+		endpoints.API = net.JoinHostPort(host, port)
+	}
+	return endpoints
+}
+
+// ByAPI returns the endpoints for the given API address (host:port)
+func (a *Addresses) ByAPI(addr string) Endpoints {
+	if a != nil {
+		if endpoints, ok := a.byAPI[addr]; ok {
+			return endpoints
+		}
+	}
+	return Endpoints{API: addr}
+}
+
+// defaults returns the endpoints of a controller at the default ports
+func (a *Addresses) defaults(host string) Endpoints {
+	return Endpoints{
+		API: net.JoinHostPort(host, a.apiPort),
+		SSH: net.JoinHostPort(host, a.sshPort),
+	}
+}
+
+// SplitTarget splits a controller address into host and optional port
+func SplitTarget(md string) (host, port string) {
+	if host, port, err := net.SplitHostPort(md); err == nil {
+		return host, port
+	}
+	// No port, maybe a bare IPv6 address
+	return unbracket(md), ""
+}
+
+// withDefaults completes a [host][:port] string with the default host and port
+func withDefaults(addr, host, port string) string {
+	if h, p, err := net.SplitHostPort(addr); err == nil {
+		if h != "" {
+			host = h
+		}
+		if p != "" {
+			port = p
+		}
+		return net.JoinHostPort(host, port)
+	}
+	if addr = unbracket(addr); addr != "" {
+		host = addr
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// unbracket removes the brackets around IPv6 addresses
+func unbracket(host string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(host), "["), "]")
+}
+
+// listFlag is a flag.Value that can be repeated to build a list
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, " ")
+}
+
+func (l *listFlag) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
@@ -0,0 +1,23 @@
+package main
+
+import "testing"
+
+func TestSplitTarget(t *testing.T) {
+	tests := []struct {
+		target, host, port string
+	}{
+		{"10.0.0.1", "10.0.0.1", ""},
+		{"10.0.0.1:4343", "10.0.0.1", "4343"},
+		{"md1.example.com", "md1.example.com", ""},
+		{"md1.example.com:22", "md1.example.com", "22"},
+		{"[2001:db8::1]:4343", "2001:db8::1", "4343"},
+		{"[2001:db8::1]", "2001:db8::1", ""},
+		{"2001:db8::1", "2001:db8::1", ""},
+	}
+	for _, test := range tests {
+		host, port := SplitTarget(test.target)
+		if host != test.host || port != test.port {
+			t.Errorf("SplitTarget(%q) is (%q, %q), expected (%q, %q)", test.target, host, port, test.host, test.port)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"os/user"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Audit actions
+const (
+	AuditLogin  = "login"
+	AuditLogout = "logout"
+	AuditShow   = "show"
+	AuditAPI    = "api"
+	AuditSSH    = "ssh"
+	AuditBackup = "backup"
+)
+
+// AuditEntry is a line in the audit log
+type AuditEntry struct {
+	Time time.Time `json:"time"`
+	// Local user running mmcollect
+	Operator string `json:"operator"`
+	// User logged in to the controller
+	User       string      `json:"user"`
+	Controller string      `json:"controller"`
+	Action     string      `json:"action"`
+	Method     string      `json:"method,omitempty"`
+	Endpoint   string      `json:"endpoint,omitempty"`
+	ConfigPath string      `json:"config_path,omitempty"`
+	Command    string      `json:"command,omitempty"`
+	Payload    interface{} `json:"payload,omitempty"`
+	Status     int         `json:"status,omitempty"`
+	Error      string      `json:"error,omitempty"`
+	// True if the operation was replayed from a cassette, not executed
+	Replayed bool `json:"replayed,omitempty"`
+}
+
+// AuditLog appends a JSON line for every operation performed
+// on the controllers
+type AuditLog struct {
+	mutex    sync.Mutex
+	file     *os.File
+	operator string
+}
+
+// NewAuditLog opens the audit log for appending
+func NewAuditLog(fname string) (*AuditLog, error) {
+	file, err := os.OpenFile(fname, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to open audit log '%s'", fname)
+	}
+	operator := os.Getenv("USER")
+	if current, err := user.Current(); err == nil {
+		operator = current.Username
+	}
+	return &AuditLog{file: file, operator: operator}, nil
+}
+
+// Record appends the entry to the log. Failures to write are logged,
+// but do not stop the run.
+func (a *AuditLog) Record(entry AuditEntry) {
+	if a == nil {
+		return
+	}
+	entry.Time, entry.Operator = time.Now(), a.operator
+	entry.Payload = redactFields(entry.Payload)
+	entry.Command, entry.Error = Redact(entry.Command), Redact(entry.Error)
+	line, err := json.Marshal(entry)
+	if err != nil {
+		log.Println("Failed to marshal audit entry:", err)
+		return
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if _, err := a.file.Write(append(line, '\n')); err != nil {
+		log.Println("Failed to write audit log:", err)
+	}
+}
+
+// Close the audit log
+func (a *AuditLog) Close() error {
+	if a == nil {
+		return nil
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return errors.WithStack(a.file.Close())
+}
+
+// errString returns the message of the error, or "" if nil
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// auditPayload decodes a request body for the audit log
+func auditPayload(body []byte) interface{} {
+	if body == nil {
+		return nil
+	}
+	var payload interface{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return string(body)
+	}
+	return payload
+}
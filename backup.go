@@ -27,15 +27,18 @@ func (c *Controller) Backup(to *url.URL) error {
 	if to.Host == "" {
 		return errors.New("Missing host for backup")
 	}
-	ips, err := net.LookupIP(to.Host)
-	if err != nil {
-		return errors.Wrapf(err, "Failed to lookup host '%s'", to.Host)
-	}
-	if len(ips) <= 0 {
-		return errors.Errorf("Failed to resolve hostname '%s' to IP address", to.Host)
+	host := to.Hostname()
+	if !tunneled(c.profile.Dialer) {
+		ips, err := net.LookupIP(host)
+		if err != nil {
+			return errors.Wrapf(err, "Failed to lookup host '%s'", host)
+		}
+		if len(ips) <= 0 {
+			return errors.Errorf("Failed to resolve hostname '%s' to IP address", host)
+		}
+		host = ips[0].String()
+		log.Printf("Backup server host resolved to %s", host)
 	}
-	host := ips[0].String()
-	log.Printf("Backup server host resolved to %s", host)
 	if to.User == nil || to.User.Username() == "" {
 		return errors.New("Missing user for backup")
 	}
@@ -49,6 +52,7 @@ func (c *Controller) Backup(to *url.URL) error {
 		pass = string(passBytes)
 		fmt.Fprintln(os.Stderr, "")
 	}
+	AddSecret(pass)
 	if to.Path == "" {
 		return errors.New("Missing path for backup")
 	}
@@ -83,11 +87,17 @@ func (c *Controller) Backup(to *url.URL) error {
 		return err
 	}
 	log.Print("Copying flash backup to remote server...")
-	if err := doCopy(c, to.Scheme, host, to.User.Username(), pass, flashFile, dir, file); err != nil {
+	if err := c.profile.Retry.Do(c.md, "backup copy", func() error {
+		return doCopy(c, to.Scheme, host, to.User.Username(), pass, flashFile, dir, file)
+	}); err != nil {
 		return err
 	}
 	log.Print("Downloading flash backup...")
-	if err := doRetrieve(to.Scheme, host, to.User.Username(), pass, dir, file); err != nil {
+	if err := c.profile.Retry.Do(c.md, "backup download", func() error {
+		err := doRetrieve(c.profile, to.Scheme, host, to.Port(), to.User.Username(), pass, dir, file)
+		c.audit(AuditEntry{Action: AuditBackup, Command: fmt.Sprintf("retrieve %s://%s@%s/%s/%s", to.Scheme, to.User.Username(), host, dir, file), Error: errString(err)})
+		return err
+	}); err != nil {
 		return err
 	}
 	return nil
@@ -105,27 +115,12 @@ func doBackup(c *Controller, fileName string) (string, error) {
 	if baseFile == "" {
 		return "", errors.Errorf("Backup file name wrong suffix, must be one of '%s'", strings.Join(suffixes, "', '"))
 	}
-	result, err := c.Post("/md", "object/flash_backup", map[string]string{
-		"backup_flash": "flash",
-		// Not suported in AOS 8.2.2.2
-		// "filename":     baseFile,
-	})
-	if err != nil {
-		return "", err
-	}
-	lookup, err := NewLookup("$._global_result.status")
-	if err != nil {
-		return "", err
-	}
-	status, err := lookup.Lookup(result)
-	if err != nil {
+	// Filename is not supported in AOS 8.2.2.2
+	backup := &FlashBackup{BackupFlash: "flash"}
+	// Backup is requested with -backup, it does not need -allow-write
+	if _, err := c.postObject("/md", backup); err != nil {
 		return "", err
 	}
-	if status, ok := status.(float64); !ok || status != 0 {
-		return "", errors.Errorf("Unexpected (float64) 0 value for backup _global_result.status: '%+v'", result)
-	}
-	// Not supported in AOS 8.2.2.2
-	// return fmt.Sprintf("%s.tar.gz", baseFile), nil
 	return "flashbackup.tar.gz", nil
 }
 
@@ -133,7 +128,15 @@ func doBackup(c *Controller, fileName string) (string, error) {
 func doCopy(c *Controller, scheme, host, user, pass, flashFile, dir, file string) error {
 	// This doesn't work through the API. The REST API always yields a 'wrong syntax' error
 	cmd := fmt.Sprintf("copy flash: %s %s: %s %s %s %s", flashFile, scheme, host, user, dir, file)
-	out, err := sshInteract(fmt.Sprintf("%s:22", c.IP()), c.username, c.password, cmd, pass)
+	steps := []Step{{
+		Expect: regexp.MustCompile(`(?i)password:\s*$`),
+		Send:   pass,
+		Secret: true,
+		// Time to wait for the upload to finish
+		Timeout: 30 * time.Minute,
+	}}
+	out, err := sshInteract(c.profile, c.sshAddr, c.profile.SSHConfig(), c.profile.Timeout, c.recorder(), cmd, steps)
+	c.audit(AuditEntry{Action: AuditBackup, Command: cmd, Error: errString(err)})
 	if err != nil {
 		return err
 	}
@@ -144,12 +147,19 @@ func doCopy(c *Controller, scheme, host, user, pass, flashFile, dir, file string
 }
 
 // doRetrieve retrieves the file from the external server
-func doRetrieve(scheme, host, user, pass, dir, file string) error {
+func doRetrieve(dialer Dialer, scheme, host, port, user, pass, dir, file string) error {
 	// Only ftp currently supported
 	if scheme != "ftp" {
 		return errors.Errorf("Scheme '%s' is not supported for local retrieval", scheme)
 	}
-	conn, err := ftp.DialTimeout(fmt.Sprintf("%s:21", host), 5*time.Second)
+	if port == "" {
+		port = "21"
+	}
+	// The timeout option is ignored with a dial function, it is applied here
+	dial := func(network, addr string) (net.Conn, error) {
+		return dialTimeout(dialer, network, addr, 5*time.Second)
+	}
+	conn, err := ftp.Dial(net.JoinHostPort(host, port), ftp.DialWithDialFunc(dial))
 	if err != nil {
 		return errors.Wrapf(err, "Failed to connect to ftp server '%s'", host)
 	}
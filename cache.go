@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// SessionCache persists API sessions to disk, so they can be
+// reused by later invocations of the program
+type SessionCache struct {
+	dir string
+}
+
+// CachedSession is the API session saved for a controller and user
+type CachedSession struct {
+	Token    string    `json:"token"`
+	Expires  time.Time `json:"expires"`
+	LastUsed time.Time `json:"last_used"`
+	Version  string    `json:"version,omitempty"`
+}
+
+// NewSessionCache returns a cache storing sessions in the given folder
+func NewSessionCache(dir string) (*SessionCache, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, errors.Wrapf(err, "Failed to create session cache folder '%s'", dir)
+	}
+	return &SessionCache{dir: dir}, nil
+}
+
+// Characters not safe for file names, get replaced by "_"
+var unsafeFileChars = regexp.MustCompile(`[^a-zA-Z0-9\.\_-]+`)
+
+func (s *SessionCache) path(md, username string) string {
+	name := unsafeFileChars.ReplaceAllString(username+"@"+md, "_")
+	return filepath.Join(s.dir, name+".json")
+}
+
+// Load the session for the given controller and user.
+// Returns false if there is no cached session, or it is no longer valid.
+func (s *SessionCache) Load(md, username string) (CachedSession, bool) {
+	var cached CachedSession
+	data, err := ioutil.ReadFile(s.path(md, username))
+	if err != nil {
+		return cached, false
+	}
+	if err := json.Unmarshal(data, &cached); err != nil || cached.Token == "" {
+		return cached, false
+	}
+	if !cached.Expires.IsZero() && cached.Expires.Before(time.Now()) {
+		return cached, false
+	}
+	return cached, true
+}
+
+// Save the session for the given controller and user
+func (s *SessionCache) Save(md, username string, cached CachedSession) error {
+	data, err := json.Marshal(cached)
+	if err != nil {
+		return errors.Wrap(err, "Failed to marshal cached session")
+	}
+	// Write to a temporary file and rename, so readers never see partial data
+	fname := s.path(md, username)
+	tmp, err := ioutil.TempFile(s.dir, ".session")
+	if err != nil {
+		return errors.Wrapf(err, "Failed to create session cache file in '%s'", s.dir)
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return errors.Wrapf(err, "Failed to set permissions of '%s'", tmp.Name())
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return errors.Wrapf(err, "Failed to write session cache file '%s'", tmp.Name())
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrapf(err, "Failed to close session cache file '%s'", tmp.Name())
+	}
+	return errors.Wrapf(os.Rename(tmp.Name(), fname), "Failed to save session cache file '%s'", fname)
+}
+
+// Remove the session for the given controller and user
+func (s *SessionCache) Remove(md, username string) error {
+	err := os.Remove(s.path(md, username))
+	if err != nil && !os.IsNotExist(err) {
+		return errors.WithStack(err)
+	}
+	return nil
+}
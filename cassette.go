@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Cassette records the API and SSH responses of a run to a folder,
+// or replays them from it without network access. Each controller
+// host gets its own file of JSON lines, with secrets redacted.
+type Cassette struct {
+	dir    string
+	replay bool
+	mutex  sync.Mutex
+	// Open files by host, when recording
+	files map[string]*os.File
+	// Recorded interactions by key, and how many were replayed
+	tapes  map[string][]Interaction
+	played map[string]int
+}
+
+// Interaction is a recorded request and its response
+type Interaction struct {
+	Kind string `json:"kind"`
+	Host string `json:"host"`
+	// HTTP request and response
+	Method  string      `json:"method,omitempty"`
+	URL     string      `json:"url,omitempty"`
+	Body    string      `json:"body,omitempty"`
+	Status  int         `json:"status,omitempty"`
+	Header  http.Header `json:"header,omitempty"`
+	Payload string      `json:"payload,omitempty"`
+	// SSH command and output
+	Command string `json:"command,omitempty"`
+	Output  string `json:"output,omitempty"`
+}
+
+// Interaction kinds
+const (
+	InteractionHTTP = "http"
+	InteractionSSH  = "ssh"
+)
+
+// NewCassette prepares the folder for recording, or loads it for replay
+func NewCassette(dir string, replay bool) (*Cassette, error) {
+	c := &Cassette{dir: dir, replay: replay, files: make(map[string]*os.File), tapes: make(map[string][]Interaction), played: make(map[string]int)}
+	if !replay {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return nil, errors.Wrapf(err, "Failed to create cassette folder '%s'", dir)
+		}
+		return c, nil
+	}
+	fnames, err := filepath.Glob(filepath.Join(dir, "*.jsonl"))
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to list cassette folder '%s'", dir)
+	}
+	if len(fnames) <= 0 {
+		return nil, errors.Errorf("No recordings found in cassette folder '%s'", dir)
+	}
+	for _, fname := range fnames {
+		if err := c.load(fname); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// load the interactions recorded in a file
+func (c *Cassette) load(fname string) error {
+	file, err := os.Open(fname)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to open cassette '%s'", fname)
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 256*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var item Interaction
+		if err := json.Unmarshal(scanner.Bytes(), &item); err != nil {
+			return errors.Wrapf(err, "Failed to decode cassette '%s', line %d", fname, line)
+		}
+		key := item.key()
+		c.tapes[key] = append(c.tapes[key], item)
+	}
+	return errors.Wrapf(scanner.Err(), "Failed to read cassette '%s'", fname)
+}
+
+// Replaying returns true if the run replays a cassette
+func (c *Cassette) Replaying() bool {
+	return c != nil && c.replay
+}
+
+// Recording returns true if the run records a cassette
+func (c *Cassette) Recording() bool {
+	return c != nil && !c.replay
+}
+
+// key identifies the request, regardless of session tokens
+func (i Interaction) key() string {
+	if i.Kind == InteractionSSH {
+		return strings.Join([]string{i.Kind, i.Host, i.Command}, " ")
+	}
+	target := i.URL
+	if parsed, err := url.Parse(i.URL); err == nil {
+		query := parsed.Query()
+		query.Del("UIDARUBA")
+		// Encode sorts the parameters
+		parsed.RawQuery = query.Encode()
+		target = parsed.Path + "?" + parsed.RawQuery
+	}
+	return strings.Join([]string{i.Kind, i.Host, i.Method, target, i.Body}, " ")
+}
+
+// record appends the interaction to the file of its host
+func (c *Cassette) record(item Interaction) error {
+	line, err := json.Marshal(item)
+	if err != nil {
+		return errors.Wrap(err, "Failed to marshal cassette interaction")
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	file, ok := c.files[item.Host]
+	if !ok {
+		fname := filepath.Join(c.dir, unsafeFileChars.ReplaceAllString(item.Host, "_")+".jsonl")
+		file, err = os.OpenFile(fname, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+		if err != nil {
+			return errors.Wrapf(err, "Failed to create cassette '%s'", fname)
+		}
+		c.files[item.Host] = file
+	}
+	_, err = file.Write(append(line, '\n'))
+	return errors.Wrapf(err, "Failed to write cassette of '%s'", item.Host)
+}
+
+// play returns the next recorded interaction for the key. Once all
+// were played, the last one is repeated.
+func (c *Cassette) play(item Interaction) (Interaction, error) {
+	key := item.key()
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	tape := c.tapes[key]
+	if len(tape) <= 0 {
+		what := item.Command
+		if item.Kind == InteractionHTTP {
+			what = item.Method + " " + item.URL
+		}
+		return item, errors.Errorf("No recording of %s '%s' on '%s' in cassette '%s'", item.Kind, what, item.Host, c.dir)
+	}
+	index := c.played[key]
+	if index >= len(tape) {
+		index = len(tape) - 1
+	}
+	c.played[key] = index + 1
+	return tape[index], nil
+}
+
+// RecordSSH records the output of a CLI command
+func (c *Cassette) RecordSSH(addr, cmd, output string) error {
+	host, _ := SplitTarget(addr)
+	return c.record(Interaction{Kind: InteractionSSH, Host: host, Command: Redact(cmd), Output: Redact(output)})
+}
+
+// ReplaySSH returns the recorded output of a CLI command
+func (c *Cassette) ReplaySSH(addr, cmd string) (string, error) {
+	host, _ := SplitTarget(addr)
+	item, err := c.play(Interaction{Kind: InteractionSSH, Host: host, Command: Redact(cmd)})
+	return item.Output, err
+}
+
+// Transport returns a RoundTripper that records the responses of next,
+// or replays them if the cassette is being replayed
+func (c *Cassette) Transport(next http.RoundTripper) http.RoundTripper {
+	return &cassetteTransport{cassette: c, next: next}
+}
+
+// Close the files of the cassette
+func (c *Cassette) Close() error {
+	if c == nil {
+		return nil
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	var err error
+	for host, file := range c.files {
+		if err1 := file.Close(); err1 != nil && err == nil {
+			err = errors.Wrapf(err1, "Failed to close cassette of '%s'", host)
+		}
+		delete(c.files, host)
+	}
+	return err
+}
+
+type cassetteTransport struct {
+	cassette *Cassette
+	next     http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *cassetteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	item := Interaction{Kind: InteractionHTTP, Host: req.URL.Hostname(), Method: req.Method, URL: Redact(req.URL.String())}
+	if req.Body != nil {
+		body, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		item.Body = string(body)
+		if strings.HasPrefix(req.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+			item.Body = redactForm(item.Body)
+		}
+		item.Body = Redact(item.Body)
+	}
+	if t.cassette.Replaying() {
+		played, err := t.cassette.play(item)
+		if err != nil {
+			return nil, err
+		}
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", played.Status, http.StatusText(played.Status)),
+			StatusCode:    played.Status,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        played.Header,
+			Body:          ioutil.NopCloser(strings.NewReader(played.Payload)),
+			ContentLength: int64(len(played.Payload)),
+			Request:       req,
+		}, nil
+	}
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		// Failures are not recorded, replay will report a missing recording
+		return nil, err
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	item.Status, item.Header, item.Payload = resp.StatusCode, cassetteHeader(resp), Redact(string(body))
+	if err := t.cassette.record(item); err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return resp, nil
+}
+
+// cassetteHeader keeps the response headers needed for replay. Cookies
+// are kept with their values redacted and no expiration, so replayed
+// sessions never expire.
+func cassetteHeader(resp *http.Response) http.Header {
+	header := make(http.Header)
+	if contentType := resp.Header.Get("Content-Type"); contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	for _, cookie := range resp.Cookies() {
+		cookie.Value, cookie.Expires, cookie.RawExpires, cookie.MaxAge = Redacted, time.Time{}, "", 0
+		header.Add("Set-Cookie", cookie.String())
+	}
+	return header
+}
+
+// offlineDialer refuses every connection, when replaying a cassette
+type offlineDialer struct{}
+
+// Dial implements Dialer
+func (offlineDialer) Dial(network, addr string) (net.Conn, error) {
+	return nil, errors.Errorf("Cannot connect to '%s' while replaying a cassette", addr)
+}
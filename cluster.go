@@ -0,0 +1,214 @@
+package main
+
+import (
+	"log"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Cluster selection modes, for -per-cluster
+const (
+	PerClusterAll    = "all"
+	PerClusterAny    = "any"
+	PerClusterLeader = "leader"
+)
+
+// Roles of a controller in its cluster
+const (
+	ClusterLeader = "leader"
+	ClusterMember = "member"
+)
+
+// Matches the cluster profile name in "show lc-cluster group-membership",
+// e.g. 'Cluster Enabled, Profile Name = "cluster1"'
+var clusterNameRegexp = regexp.MustCompile(`(?i)(?:profile|cluster)\s+name\s*[:=]\s*"?([^",\s]+)`)
+
+// clusterFromShow extracts the cluster name, the role of the controller
+// and the addresses of the members from the output of
+// "show lc-cluster group-membership".
+// Returns empty values if the controller is not in a cluster.
+func clusterFromShow(data interface{}) (name, role string, members []string) {
+	// Text lines, either from CLI output or from "_data" in API output
+	lines := make([]string, 0)
+	walkStrings(data, func(line string) {
+		lines = append(lines, line)
+	})
+	for _, line := range lines {
+		if match := clusterNameRegexp.FindStringSubmatch(line); match != nil {
+			name = match[1]
+			break
+		}
+	}
+	if name == "" {
+		return "", "", nil
+	}
+	role = ClusterMember
+	unique := make(map[string]bool)
+	member := func(kind, status string, values []string) {
+		if !strings.EqualFold(kind, "self") && !strings.EqualFold(kind, "peer") {
+			return
+		}
+		for _, value := range values {
+			if net.ParseIP(value) != nil {
+				unique[value] = true
+			}
+		}
+		if strings.EqualFold(kind, "self") && strings.Contains(strings.ToLower(status), "leader") {
+			role = ClusterLeader
+		}
+	}
+	// API output has a table with a row per member
+	walkMaps(data, func(row map[string]interface{}) {
+		kind, ok := row["Type"].(string)
+		if !ok {
+			return
+		}
+		var status string
+		values := make([]string, 0, len(row))
+		for key, value := range row {
+			if text, ok := value.(string); ok {
+				values = append(values, text)
+				if strings.EqualFold(key, "status") {
+					status = text
+				}
+			}
+		}
+		member(kind, status, values)
+	})
+	// CLI output has lines like "self  10.0.0.1  128  N/A  CONNECTED (Leader)"
+	for _, line := range lines {
+		if fields := strings.Fields(line); len(fields) > 1 {
+			member(fields[0], line, fields[1:])
+		}
+	}
+	for value := range unique {
+		members = append(members, value)
+	}
+	sort.Strings(members)
+	return name, role, members
+}
+
+// clusterKey identifies the cluster of the switch. Profile names are
+// not unique, so the members are used, or the node if not known.
+func clusterKey(sw Switch) string {
+	if len(sw.ClusterMembers) > 0 {
+		return sw.Cluster + " " + strings.Join(sw.ClusterMembers, ",")
+	}
+	return sw.Cluster + " " + sw.Node
+}
+
+// walkMaps calls f for every object nested in data
+func walkMaps(data interface{}, f func(map[string]interface{})) {
+	switch data := data.(type) {
+	case map[string]interface{}:
+		f(data)
+		for _, v := range data {
+			walkMaps(v, f)
+		}
+	case []interface{}:
+		for _, v := range data {
+			walkMaps(v, f)
+		}
+	}
+}
+
+// walkStrings calls f for every string nested in data
+func walkStrings(data interface{}, f func(string)) {
+	switch data := data.(type) {
+	case string:
+		f(data)
+	case []string:
+		for _, v := range data {
+			f(v)
+		}
+	case map[string]interface{}:
+		for _, v := range data {
+			walkStrings(v, f)
+		}
+	case []interface{}:
+		for _, v := range data {
+			walkStrings(v, f)
+		}
+	}
+}
+
+// AssignClusters queries the cluster membership of each switch, with
+// the given parallelism, and sets its Cluster and ClusterRole.
+// Membership is always read via API, the CLI output is not structured.
+// The sessions are kept in the profile Sessions, for the pool to reuse.
+// Switches that fail to answer are considered not clustered.
+func AssignClusters(switches []Switch, profile *Profile, tasks int) {
+	wg, sem := sync.WaitGroup{}, make(chan struct{}, tasks)
+	for i := range switches {
+		wg.Add(1)
+		go func(sw *Switch) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			controller := NewController(sw.IP, profile, false)
+			controller.info = *sw
+			if err := controller.Dial(); err != nil {
+				controller.Close()
+				log.Println("Failed to get cluster membership of", sw.Label(), ":", err)
+				return
+			}
+			defer profile.Sessions.Put(controller)
+			data, err := controller.Show("show lc-cluster group-membership", nil)
+			if err != nil {
+				log.Println("Failed to get cluster membership of", sw.Label(), ":", err)
+				return
+			}
+			sw.Cluster, sw.ClusterRole, sw.ClusterMembers = clusterFromShow(data)
+		}(&switches[i])
+	}
+	wg.Wait()
+}
+
+// PerCluster selects the switches of each cluster according to mode:
+// "all" keeps every member, "leader" only the leader, and "any" a single
+// member (the leader, if known). Switches not in a cluster are always kept.
+func PerCluster(switches []Switch, mode string) ([]Switch, error) {
+	switch mode {
+	case PerClusterAll:
+		return switches, nil
+	case PerClusterAny, PerClusterLeader:
+	default:
+		return nil, errors.Errorf("Unknown cluster selection '%s', must be one of %s, %s or %s", mode, PerClusterLeader, PerClusterAny, PerClusterAll)
+	}
+	// Find the chosen member of each cluster
+	chosen := make(map[string]int)
+	for i, sw := range switches {
+		if sw.Cluster == "" {
+			continue
+		}
+		key := clusterKey(sw)
+		current, ok := chosen[key]
+		if sw.ClusterRole == ClusterLeader && (!ok || switches[current].ClusterRole != ClusterLeader) {
+			chosen[key] = i
+		} else if !ok && mode == PerClusterAny {
+			chosen[key] = i
+		}
+	}
+	selected := make([]Switch, 0, len(switches))
+	for i, sw := range switches {
+		if sw.Cluster == "" {
+			selected = append(selected, sw)
+			continue
+		}
+		key := clusterKey(sw)
+		index, ok := chosen[key]
+		if ok && index == i {
+			selected = append(selected, sw)
+		} else if !ok {
+			// Warn just once per cluster
+			chosen[key] = -1
+			log.Println("No leader found for cluster", sw.Cluster, "skipping its members")
+		}
+	}
+	return selected, nil
+}
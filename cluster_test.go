@@ -0,0 +1,152 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestPerClusterSameProfileName(t *testing.T) {
+	switches := []Switch{
+		{IP: "10.0.0.1", Cluster: "cluster1", ClusterRole: ClusterLeader, ClusterMembers: []string{"10.0.0.1", "10.0.0.2"}},
+		{IP: "10.0.0.2", Cluster: "cluster1", ClusterRole: ClusterMember, ClusterMembers: []string{"10.0.0.1", "10.0.0.2"}},
+		{IP: "10.1.0.1", Cluster: "cluster1", ClusterRole: ClusterMember, ClusterMembers: []string{"10.1.0.1", "10.1.0.2"}},
+		{IP: "10.1.0.2", Cluster: "cluster1", ClusterRole: ClusterLeader, ClusterMembers: []string{"10.1.0.1", "10.1.0.2"}},
+		{IP: "10.2.0.1", Cluster: "cluster1", ClusterRole: ClusterMember, Node: "/md/EMEA"},
+		{IP: "10.3.0.1", Cluster: "cluster1", ClusterRole: ClusterMember, Node: "/md/APAC"},
+		{IP: "10.9.0.1"},
+	}
+	tests := []struct {
+		mode string
+		ips  []string
+	}{
+		{PerClusterLeader, []string{"10.0.0.1", "10.1.0.2", "10.9.0.1"}},
+		{PerClusterAny, []string{"10.0.0.1", "10.1.0.2", "10.2.0.1", "10.3.0.1", "10.9.0.1"}},
+	}
+	for _, test := range tests {
+		selected, err := PerCluster(switches, test.mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(selected) != len(test.ips) {
+			t.Fatalf("Mode %s selected %v, expected %v", test.mode, selected, test.ips)
+		}
+		for i, sw := range selected {
+			if sw.IP != test.ips[i] {
+				t.Errorf("Mode %s selected %s at %d, expected %s", test.mode, sw.IP, i, test.ips[i])
+			}
+		}
+	}
+}
+
+func TestClusterFromShow(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    interface{}
+		cluster string
+		role    string
+		members []string
+	}{
+		{
+			name: "api",
+			data: []interface{}{
+				`Cluster Enabled, Profile Name = "cluster1"`,
+				map[string]interface{}{"Type": "peer", "IPv4_Address": "10.0.0.2", "STATUS": "CONNECTED (Member)"},
+				map[string]interface{}{"Type": "self", "IPv4_Address": "10.0.0.1", "STATUS": "CONNECTED (Leader)"},
+			},
+			cluster: "cluster1",
+			role:    ClusterLeader,
+			members: []string{"10.0.0.1", "10.0.0.2"},
+		},
+		{
+			name: "ssh",
+			data: []string{
+				`Cluster Enabled, Profile Name = "cluster1"`,
+				"Cluster Info Table",
+				"------------------",
+				"Type IPv4 Address    Priority Connection-Type STATUS",
+				"---- --------------- -------- --------------- ------",
+				"peer     10.0.0.1         128    L2-Connected CONNECTED (Leader, last HBT_RSP 0ms ago, rtt 0ms)",
+				"self     10.0.0.2         128             N/A CONNECTED (Member)",
+			},
+			cluster: "cluster1",
+			role:    ClusterMember,
+			members: []string{"10.0.0.1", "10.0.0.2"},
+		},
+		{
+			name:    "not clustered",
+			data:    []string{"Cluster Disabled"},
+			members: nil,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			name, role, members := clusterFromShow(test.data)
+			if name != test.cluster || role != test.role {
+				t.Errorf("Got cluster %q role %q, expected %q %q", name, role, test.cluster, test.role)
+			}
+			if !reflect.DeepEqual(members, test.members) {
+				t.Errorf("Got members %v, expected %v", members, test.members)
+			}
+		})
+	}
+}
+
+func TestAssignClustersReusesSessions(t *testing.T) {
+	var logins, logouts int32
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/api/login":
+			atomic.AddInt32(&logins, 1)
+			http.SetCookie(w, &http.Cookie{Name: "SESSION", Value: "token", Path: "/"})
+			fmt.Fprint(w, `{"_global_result":{"status":0,"UIDARUBA":"token"}}`)
+		case "/v1/api/logout":
+			atomic.AddInt32(&logouts, 1)
+			fmt.Fprint(w, "You've been logged out successfully.")
+		case "/v1/configuration/showcommand":
+			switch r.URL.Query().Get("command") {
+			case "show lc-cluster group-membership":
+				fmt.Fprint(w, `{"_data":["Cluster Enabled, Profile Name = \"cluster1\""],"Cluster Info Table":[{"Type":"self","IPv4 Address":"10.0.0.1","STATUS":"CONNECTED (Leader)"}]}`)
+			case "show version":
+				fmt.Fprint(w, `{"_data":["Aruba Operating System Software.","ArubaOS (MODEL: Aruba7005), Version 8.6.0.4"]}`)
+			default:
+				fmt.Fprint(w, `{"_data":["ok"]}`)
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	jar, _ := cookiejar.New(nil)
+	profile := &Profile{
+		Client:   &http.Client{Jar: jar, Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}},
+		Sessions: NewSessions(),
+	}
+	switches := []Switch{{IP: strings.TrimPrefix(server.URL, "https://")}}
+	// Cluster membership is read via API, even if the run uses SSH
+	AssignClusters(switches, profile, 1)
+	if switches[0].Cluster != "cluster1" || switches[0].ClusterRole != ClusterLeader {
+		t.Fatalf("Got cluster %q role %q", switches[0].Cluster, switches[0].ClusterRole)
+	}
+	task, err := ParseTask("show clock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := NewPool(1, 0, 0, profile)
+	for result := range pool.Push(switches[0], []Task{task}, nil, false) {
+		if result.Err != nil {
+			t.Fatal(result.Err)
+		}
+	}
+	pool.Close()
+	profile.Sessions.Close()
+	if logins != 1 || logouts != 1 {
+		t.Errorf("Logged in %d times and out %d times, expected once", logins, logouts)
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/net/proxy"
+)
+
+// Dialer opens network connections to the controllers.
+// It is compatible with golang.org/x/net/proxy.Dialer
+type Dialer interface {
+	Dial(network, addr string) (net.Conn, error)
+}
+
+// NewDialer builds a Dialer that reaches the controllers through an optional
+// proxy (socks5:// or http:// URL), and a chain of SSH jump hosts
+// ([user@]host[:port], the first one being the closest).
+// Jump hosts use the given SSH configuration, replacing the user if specified.
+func NewDialer(timeout time.Duration, proxyURL string, jumps []string, config *ssh.ClientConfig) (Dialer, error) {
+	var dialer Dialer = &net.Dialer{Timeout: timeout}
+	if proxyURL != "" {
+		u, err := url.Parse(proxyURL)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to parse proxy URL '%s'", proxyURL)
+		}
+		switch u.Scheme {
+		case "socks5", "socks5h":
+			if u.Port() == "" {
+				u.Host = net.JoinHostPort(u.Hostname(), "1080")
+			}
+			socks, err := proxy.FromURL(u, dialer)
+			if err != nil {
+				return nil, errors.Wrapf(err, "Failed to build SOCKS5 proxy for '%s'", u.Host)
+			}
+			dialer = socks
+		case "http":
+			if u.Port() == "" {
+				u.Host = net.JoinHostPort(u.Hostname(), "3128")
+			}
+			dialer = &connectDialer{proxy: u, forward: dialer}
+		default:
+			return nil, errors.Errorf("Proxy scheme '%s' not supported, must be 'socks5' or 'http'", u.Scheme)
+		}
+	}
+	for _, jump := range jumps {
+		hop := *config
+		addr := jump
+		if at := strings.LastIndex(jump, "@"); at >= 0 {
+			hop.User, addr = jump[:at], jump[at+1:]
+		}
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			addr = net.JoinHostPort(strings.Trim(addr, "[]"), "22")
+		}
+		dialer = &jumpDialer{addr: addr, config: &hop, forward: dialer}
+	}
+	return dialer, nil
+}
+
+// tunneled returns true if the dialer goes through a proxy or jump host.
+// Host names may only resolve at the remote end of the tunnel.
+func tunneled(dialer Dialer) bool {
+	switch dialer.(type) {
+	case nil, *net.Dialer:
+		return false
+	}
+	return true
+}
+
+// dialTimeout opens a connection with the dialer, giving up after the
+// timeout. Dialers without context support are left to finish in the
+// background, and the connection is closed if it arrives too late.
+func dialTimeout(dialer Dialer, network, addr string, timeout time.Duration) (net.Conn, error) {
+	if d, ok := dialer.(proxy.ContextDialer); ok {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		return d.DialContext(ctx, network, addr)
+	}
+	type dialed struct {
+		conn net.Conn
+		err  error
+	}
+	done := make(chan dialed, 1)
+	go func() {
+		conn, err := dialer.Dial(network, addr)
+		done <- dialed{conn: conn, err: err}
+	}()
+	select {
+	case result := <-done:
+		return result.conn, result.err
+	case <-time.After(timeout):
+		go func() {
+			if result := <-done; result.conn != nil {
+				result.conn.Close()
+			}
+		}()
+		return nil, &net.OpError{Op: "dial", Net: network, Err: context.DeadlineExceeded}
+	}
+}
+
+// sshDial opens an SSH connection to addr, using the given dialer
+func sshDial(dialer Dialer, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	conn, err := dialer.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// jumpDialer tunnels connections through an SSH jump host
+// (like OpenSSH's ProxyJump). The SSH connection is shared.
+type jumpDialer struct {
+	addr    string
+	config  *ssh.ClientConfig
+	forward Dialer
+	mutex   sync.Mutex
+	client  *ssh.Client
+}
+
+// Dial implements Dialer
+func (d *jumpDialer) Dial(network, addr string) (net.Conn, error) {
+	client, err := d.connect(nil)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := client.Dial(network, addr)
+	if err != nil && d.broken(client, err) {
+		// The connection to the jump host is broken, try again
+		if client, err = d.connect(client); err != nil {
+			return nil, err
+		}
+		conn, err = client.Dial(network, addr)
+	}
+	return conn, errors.Wrapf(err, "Failed to dial '%s' through jump host '%s'", addr, d.addr)
+}
+
+// broken returns true if the dial error was caused by the connection
+// to the jump host, not by the target being unreachable from it.
+// Other tunnels share the connection, it must not be reset lightly.
+func (d *jumpDialer) broken(client *ssh.Client, err error) bool {
+	var rejected *ssh.OpenChannelError
+	if errors.As(err, &rejected) {
+		return false
+	}
+	// Any reply, even a failure, means the jump host is alive
+	_, _, err = client.SendRequest("keepalive@openssh.com", true, nil)
+	return err != nil
+}
+
+// connect to the jump host, if not already connected. If dead is
+// the current connection, it is closed and replaced by a new one.
+func (d *jumpDialer) connect(dead *ssh.Client) (*ssh.Client, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if dead != nil && d.client == dead {
+		d.client.Close()
+		d.client = nil
+	}
+	if d.client == nil {
+		client, err := sshDial(d.forward, d.addr, d.config)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to connect to jump host '%s'", d.addr)
+		}
+		d.client = client
+	}
+	return d.client, nil
+}
+
+// connectDialer tunnels connections through an HTTP proxy, using CONNECT
+type connectDialer struct {
+	proxy   *url.URL
+	forward Dialer
+}
+
+// Dial implements Dialer
+func (d *connectDialer) Dial(network, addr string) (net.Conn, error) {
+	conn, err := d.forward.Dial("tcp", d.proxy.Host)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to connect to proxy '%s'", d.proxy.Host)
+	}
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if d.proxy.User != nil {
+		pass, _ := d.proxy.User.Password()
+		auth := base64.StdEncoding.EncodeToString([]byte(d.proxy.User.Username() + ":" + pass))
+		req.Header.Set("Proxy-Authorization", "Basic "+auth)
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, errors.Wrapf(err, "Failed to send CONNECT to proxy '%s'", d.proxy.Host)
+	}
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		conn.Close()
+		return nil, errors.Wrapf(err, "Failed to read CONNECT response from proxy '%s'", d.proxy.Host)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, errors.Errorf("Proxy '%s' refused to connect to '%s': %s", d.proxy.Host, addr, resp.Status)
+	}
+	if reader.Buffered() > 0 {
+		// Do not lose any data read ahead by the buffer
+		return &bufferedConn{Conn: conn, reader: reader}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn is a connection with some data already buffered
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// slowDialer waits before connecting, and records if the connection was closed
+type slowDialer struct {
+	delay  time.Duration
+	closed chan bool
+}
+
+func (d *slowDialer) Dial(network, addr string) (net.Conn, error) {
+	time.Sleep(d.delay)
+	client, server := net.Pipe()
+	go func() {
+		buf := make([]byte, 1)
+		_, err := server.Read(buf)
+		d.closed <- err != nil
+	}()
+	return client, nil
+}
+
+func TestDialTimeout(t *testing.T) {
+	slow := &slowDialer{delay: 200 * time.Millisecond, closed: make(chan bool, 1)}
+	conn, err := dialTimeout(slow, "tcp", "10.0.0.1:21", 20*time.Millisecond)
+	if err == nil {
+		conn.Close()
+		t.Fatal("Expected timeout")
+	}
+	if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+		t.Errorf("Expected a timeout error, got %v", err)
+	}
+	select {
+	case closed := <-slow.closed:
+		if !closed {
+			t.Error("Late connection was not closed")
+		}
+	case <-time.After(time.Second):
+		t.Error("Late connection was not closed")
+	}
+	fast := &slowDialer{closed: make(chan bool, 1)}
+	conn, err = dialTimeout(fast, "tcp", "10.0.0.1:21", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
+
+func TestTunneled(t *testing.T) {
+	tests := []struct {
+		dialer   Dialer
+		tunneled bool
+	}{
+		{nil, false},
+		{&net.Dialer{}, false},
+		{&jumpDialer{}, true},
+		{&connectDialer{}, true},
+	}
+	for _, test := range tests {
+		if tunneled(test.dialer) != test.tunneled {
+			t.Errorf("tunneled(%T) is %v, expected %v", test.dialer, !test.tunneled, test.tunneled)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// GlobalResult is the "_global_result" object in API responses
+type GlobalResult struct {
+	Status    int    `json:"-"`
+	StatusStr string `json:"status_str"`
+	UIDARUBA  string `json:"UIDARUBA,omitempty"`
+}
+
+// UnmarshalJSON implements json.Unmarshaler. Status can be
+// a number or a string, depending on the endpoint.
+func (g *GlobalResult) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Status    interface{} `json:"status"`
+		StatusStr string      `json:"status_str"`
+		UIDARUBA  string      `json:"UIDARUBA"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	g.StatusStr, g.UIDARUBA = raw.StatusStr, raw.UIDARUBA
+	switch status := raw.Status.(type) {
+	case float64:
+		g.Status = int(status)
+	case string:
+		code, err := strconv.Atoi(strings.TrimSpace(status))
+		if err != nil {
+			return errors.Errorf("Unexpected _global_result.status '%s'", status)
+		}
+		g.Status = code
+	case nil:
+		g.Status = 0
+	default:
+		return errors.Errorf("Unexpected _global_result.status '%v'", status)
+	}
+	return nil
+}
+
+// AuthError is returned when a controller rejects the credentials
+type AuthError struct {
+	MD  string
+	Op  string
+	Err error
+}
+
+func (e *AuthError) Error() string {
+	return fmt.Sprintf("MD '%s': %s: authentication failed: %v", e.MD, e.Op, e.Err)
+}
+
+// Unwrap returns the cause of the error
+func (e *AuthError) Unwrap() error { return e.Err }
+
+// Cause implements github.com/pkg/errors causer
+func (e *AuthError) Cause() error { return e.Err }
+
+// TransportError is returned when a controller can't be reached
+// (connection refused or reset, timeouts, TLS or SSH failures)
+type TransportError struct {
+	MD  string
+	Op  string
+	Err error
+}
+
+func (e *TransportError) Error() string {
+	return fmt.Sprintf("MD '%s': %s: %v", e.MD, e.Op, e.Err)
+}
+
+// Unwrap returns the cause of the error
+func (e *TransportError) Unwrap() error { return e.Err }
+
+// Cause implements github.com/pkg/errors causer
+func (e *TransportError) Cause() error { return e.Err }
+
+// APIStatusError is returned when the API replies with an unexpected
+// HTTP status, or a "_global_result" with an error status
+type APIStatusError struct {
+	MD           string
+	Op           string
+	StatusCode   int
+	GlobalResult *GlobalResult
+}
+
+func (e *APIStatusError) Error() string {
+	if e.GlobalResult != nil {
+		return fmt.Sprintf("MD '%s': %s returned status %d (%s)", e.MD, e.Op, e.GlobalResult.Status, e.GlobalResult.StatusStr)
+	}
+	return fmt.Sprintf("MD '%s': %s returned error code %d", e.MD, e.Op, e.StatusCode)
+}
+
+// DecodeError is returned when a response can't be decoded
+type DecodeError struct {
+	MD   string
+	Op   string
+	Body string
+	Err  error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("MD '%s': %s: failed to decode data '%s': %v", e.MD, e.Op, e.Body, e.Err)
+}
+
+// Unwrap returns the cause of the error
+func (e *DecodeError) Unwrap() error { return e.Err }
+
+// Cause implements github.com/pkg/errors causer
+func (e *DecodeError) Cause() error { return e.Err }
+
+// ScriptError is returned when a script fails to run
+type ScriptError struct {
+	MD     string
+	Script string
+	Err    error
+}
+
+func (e *ScriptError) Error() string {
+	return fmt.Sprintf("MD '%s': script '%s' failed: %v", e.MD, e.Script, e.Err)
+}
+
+// Unwrap returns the cause of the error
+func (e *ScriptError) Unwrap() error { return e.Err }
+
+// Cause implements github.com/pkg/errors causer
+func (e *ScriptError) Cause() error { return e.Err }
+
+// FilterError is returned when a filter fails to compile or run
+type FilterError struct {
+	Filter string
+	Err    error
+}
+
+func (e *FilterError) Error() string {
+	return fmt.Sprintf("Filter '%s' failed: %v", e.Filter, e.Err)
+}
+
+// Unwrap returns the cause of the error
+func (e *FilterError) Unwrap() error { return e.Err }
+
+// Cause implements github.com/pkg/errors causer
+func (e *FilterError) Cause() error { return e.Err }
+
+// ReadOnlyError is returned when an operation that may change
+// the controller is attempted in a read-only run
+type ReadOnlyError struct {
+	MD     string
+	Op     string
+	Reason string
+}
+
+func (e *ReadOnlyError) Error() string {
+	return fmt.Sprintf("MD '%s': %s rejected: %s", e.MD, e.Op, e.Reason)
+}
+
+// ErrorKind classifies errors for run summaries
+func ErrorKind(err error) string {
+	var (
+		authErr      *AuthError
+		transportErr *TransportError
+		statusErr    *APIStatusError
+		decodeErr    *DecodeError
+		scriptErr    *ScriptError
+		filterErr    *FilterError
+		readOnlyErr  *ReadOnlyError
+	)
+	switch {
+	case err == nil:
+		return ""
+	case errors.As(err, &authErr):
+		return "auth"
+	case errors.As(err, &readOnlyErr):
+		return "read-only"
+	case errors.As(err, &statusErr):
+		return "api status"
+	case errors.As(err, &decodeErr):
+		return "decode"
+	case errors.As(err, &filterErr):
+		return "filter"
+	case errors.As(err, &scriptErr):
+		return "script"
+	case errors.As(err, &transportErr):
+		return "transport"
+	}
+	return "other"
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+)
+
+// PromptAOS matches the prompt of the AOS CLI at the end of the output,
+// e.g. "(host) #", "(host) >", "(host) [mynode] #", "(host) *[mynode] #".
+// AOS 8 shows "^" before the node when there are uncommitted changes.
+var PromptAOS = regexp.MustCompile(`\([^()\n]+\) ?[*^]?(\[[^\]\n]*\])? ?[#>] ?$`)
+
+// Step is a prompt expected during a command, and the response to send
+type Step struct {
+	Expect *regexp.Regexp
+	Send   string
+	// Secret responses (e.g. passwords) are not echoed to logs or transcripts
+	Secret bool
+	// Maximum time to wait for more output after sending the response.
+	// If 0, the Expect timeout.
+	Timeout time.Duration
+}
+
+// Expect drives an interactive CLI session over SSH, like the expect tool
+type Expect struct {
+	session *ssh.Session
+	stdin   io.WriteCloser
+	prompt  *regexp.Regexp
+	timeout time.Duration
+	// Transcript of the session, if recorded
+	transcript *Transcript
+	// Output received and not yet consumed
+	mutex   sync.Mutex
+	pending bytes.Buffer
+	notify  chan struct{}
+	closed  bool
+}
+
+// NewExpect opens a shell with a PTY, waits for the CLI prompt and
+// disables paging. The timeout applies to every expected prompt.
+// The session is recorded to the transcript, if not nil.
+func NewExpect(client *ssh.Client, timeout time.Duration, transcript *Transcript) (*Expect, error) {
+	if timeout <= 0 {
+		timeout = 60 * time.Second
+	}
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to create SSH session")
+	}
+	e := &Expect{
+		session:    session,
+		prompt:     PromptAOS,
+		timeout:    timeout,
+		notify:     make(chan struct{}, 1),
+		transcript: transcript,
+	}
+	transcript.Comment(fmt.Sprintf("session opened to %s", client.RemoteAddr()))
+	if err := e.start(); err != nil {
+		session.Close()
+		return nil, err
+	}
+	if _, _, err := e.Expect(0, e.prompt); err != nil {
+		e.session.Close()
+		return nil, errors.Wrap(err, "Failed to get CLI prompt")
+	}
+	if _, err := e.Command("no paging"); err != nil {
+		e.session.Close()
+		return nil, errors.Wrap(err, "Failed to disable paging")
+	}
+	return e, nil
+}
+
+// start the shell, and the goroutine collecting the output
+func (e *Expect) start() error {
+	modes := ssh.TerminalModes{
+		ssh.ECHO:          1,
+		ssh.TTY_OP_ISPEED: 38400,
+		ssh.TTY_OP_OSPEED: 38400,
+	}
+	// Wide terminal, so the CLI does not wrap long lines
+	if err := e.session.RequestPty("vt100", 1000, 512, modes); err != nil {
+		return errors.Wrap(err, "Failed to request PTY")
+	}
+	stdin, err := e.session.StdinPipe()
+	if err != nil {
+		return errors.Wrap(err, "Failed to get SSH stdin")
+	}
+	stdout, err := e.session.StdoutPipe()
+	if err != nil {
+		return errors.Wrap(err, "Failed to get SSH stdout")
+	}
+	e.stdin = stdin
+	if err := e.session.Shell(); err != nil {
+		return errors.Wrap(err, "Failed to start remote shell")
+	}
+	go e.collect(stdout)
+	return nil
+}
+
+// collect the output of the session, until it is closed
+func (e *Expect) collect(stdout io.Reader) {
+	buffer := make([]byte, 4096)
+	for {
+		n, err := stdout.Read(buffer)
+		if n > 0 {
+			e.transcript.Output(buffer[:n])
+		}
+		e.mutex.Lock()
+		e.pending.Write(buffer[:n])
+		if err != nil {
+			e.closed = true
+		}
+		e.mutex.Unlock()
+		select {
+		case e.notify <- struct{}{}:
+		default:
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Send some text to the session
+func (e *Expect) Send(text string) error {
+	return e.send(text, false)
+}
+
+// send text to the session, redacting secrets from the transcript
+func (e *Expect) send(text string, secret bool) error {
+	e.transcript.Input(text, secret)
+	_, err := io.WriteString(e.stdin, text)
+	return errors.Wrap(err, "Failed to send to SSH session")
+}
+
+// Expect waits until the output matches one of the patterns, and returns
+// the index of the pattern and the output up to the end of the match.
+// If timeout is 0, the default timeout is used.
+func (e *Expect) Expect(timeout time.Duration, patterns ...*regexp.Regexp) (int, string, error) {
+	if timeout <= 0 {
+		timeout = e.timeout
+	}
+	deadline := time.After(timeout)
+	for {
+		e.mutex.Lock()
+		// PTYs use CRLF line endings
+		text := strings.Replace(e.pending.String(), "\r\n", "\n", -1)
+		text = strings.Replace(text, "\r", "", -1)
+		for index, pattern := range patterns {
+			if loc := pattern.FindStringIndex(text); loc != nil {
+				// Consume the output up to the end of the match
+				e.pending.Reset()
+				e.pending.WriteString(text[loc[1]:])
+				e.mutex.Unlock()
+				return index, text[:loc[1]], nil
+			}
+		}
+		closed := e.closed
+		e.mutex.Unlock()
+		if closed {
+			return -1, text, errors.Errorf("SSH session closed while waiting for '%s'", patterns)
+		}
+		select {
+		case <-e.notify:
+		case <-deadline:
+			return -1, text, errors.Errorf("Timeout waiting for '%s', got '%s'", patterns, lastLine(text))
+		}
+	}
+}
+
+// Command runs a CLI command and returns its output, without the
+// echoed command and the final prompt.
+func (e *Expect) Command(cmd string) (string, error) {
+	return e.Script(cmd, nil)
+}
+
+// Script runs a CLI command, answering the prompts described by the
+// steps until the CLI prompt is back. Returns the command output.
+func (e *Expect) Script(cmd string, steps []Step) (string, error) {
+	if err := e.Send(cmd + "\n"); err != nil {
+		return "", err
+	}
+	patterns := make([]*regexp.Regexp, 0, len(steps)+1)
+	patterns = append(patterns, e.prompt)
+	for _, step := range steps {
+		patterns = append(patterns, step.Expect)
+	}
+	var output strings.Builder
+	timeout := time.Duration(0)
+	for {
+		index, text, err := e.Expect(timeout, patterns...)
+		output.WriteString(text)
+		if err != nil {
+			return output.String(), errors.Wrapf(err, "Failed to run '%s'", cmd)
+		}
+		if index == 0 {
+			break
+		}
+		step := steps[index-1]
+		timeout = step.Timeout
+		if err := e.send(step.Send+"\n", step.Secret); err != nil {
+			return output.String(), err
+		}
+	}
+	return trimCommand(output.String(), cmd, e.prompt), nil
+}
+
+// Close leaves the CLI and closes the session
+func (e *Expect) Close() error {
+	e.Send("exit\n")
+	done := make(chan error, 1)
+	go func() { done <- e.session.Wait() }()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+	}
+	err := e.session.Close()
+	if err == io.EOF {
+		// Session already closed by the remote side
+		err = nil
+	}
+	e.transcript.Comment("session closed")
+	return err
+}
+
+// trimCommand removes the echoed command and the trailing prompt from the output
+func trimCommand(output, cmd string, prompt *regexp.Regexp) string {
+	if loc := prompt.FindStringIndex(output); loc != nil {
+		output = output[:loc[0]]
+	}
+	if index := strings.Index(output, cmd); index >= 0 && !strings.Contains(output[:index], "\n") {
+		output = output[index+len(cmd):]
+	}
+	return strings.Trim(output, "\n")
+}
+
+// lastLine returns the last non-empty line of the text, for error messages
+func lastLine(text string) string {
+	lines := SplitNonEmpty(text, "\n")
+	if len(lines) <= 0 {
+		return ""
+	}
+	return lines[len(lines)-1]
+}
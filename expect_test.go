@@ -0,0 +1,27 @@
+package main
+
+import "testing"
+
+func TestPromptAOS(t *testing.T) {
+	tests := []struct {
+		output string
+		match  bool
+	}{
+		{"(host) #", true},
+		{"(host) >", true},
+		{"(host) # ", true},
+		{"(host) [mynode] #", true},
+		{"(mm1) *[mynode] #", true},
+		{"(mm1) ^[mynode] #", true},
+		{"(mm1) ^[mynode] (config) #", true},
+		{"show version\n(host) #", true},
+		{"(host) # show version", false},
+		{"Password:", false},
+		{"[mynode] #", false},
+	}
+	for _, test := range tests {
+		if match := PromptAOS.MatchString(test.output); match != test.match {
+			t.Errorf("PromptAOS match of %q is %v, expected %v", test.output, match, test.match)
+		}
+	}
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// HAR writes HTTP requests and responses to a file in HTTP Archive
+// format (http://www.softwareishard.com/blog/har-12-spec/). The file
+// is valid JSON after every entry, so it can be opened even if the
+// run is interrupted.
+type HAR struct {
+	mutex   sync.Mutex
+	file    *os.File
+	entries int
+}
+
+const (
+	harHeader  = `{"log":{"version":"1.2","creator":{"name":"mmcollect","version":"1.0"},"entries":[`
+	harTrailer = "]}}\n"
+)
+
+type harEntry struct {
+	StartedDateTime time.Time   `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+	Comment         string      `json:"comment,omitempty"`
+}
+
+type harRequest struct {
+	Method      string       `json:"method"`
+	URL         string       `json:"url"`
+	HTTPVersion string       `json:"httpVersion"`
+	Cookies     []harNV      `json:"cookies"`
+	Headers     []harNV      `json:"headers"`
+	QueryString []harNV      `json:"queryString"`
+	PostData    *harPostData `json:"postData,omitempty"`
+	HeadersSize int          `json:"headersSize"`
+	BodySize    int          `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status      int        `json:"status"`
+	StatusText  string     `json:"statusText"`
+	HTTPVersion string     `json:"httpVersion"`
+	Cookies     []harNV    `json:"cookies"`
+	Headers     []harNV    `json:"headers"`
+	Content     harContent `json:"content"`
+	RedirectURL string     `json:"redirectURL"`
+	HeadersSize int        `json:"headersSize"`
+	BodySize    int        `json:"bodySize"`
+}
+
+type harNV struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type harContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type harTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// NewHAR creates the HAR file
+func NewHAR(fname string) (*HAR, error) {
+	file, err := os.OpenFile(fname, os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to create HAR file '%s'", fname)
+	}
+	if _, err := io.WriteString(file, harHeader+harTrailer); err != nil {
+		file.Close()
+		return nil, errors.Wrapf(err, "Failed to write HAR file '%s'", fname)
+	}
+	return &HAR{file: file}, nil
+}
+
+// add an entry, overwriting the trailer of the file
+func (h *HAR) add(entry harEntry) {
+	if h == nil {
+		return
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		log.Println("Failed to marshal HAR entry:", err)
+		return
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if _, err := h.file.Seek(-int64(len(harTrailer)), io.SeekEnd); err != nil {
+		log.Println("Failed to write HAR file:", err)
+		return
+	}
+	if h.entries > 0 {
+		data = append([]byte(","), data...)
+	}
+	if _, err := h.file.Write(append(data, harTrailer...)); err != nil {
+		log.Println("Failed to write HAR file:", err)
+		return
+	}
+	h.entries++
+}
+
+// Close the HAR file
+func (h *HAR) Close() error {
+	if h == nil {
+		return nil
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return errors.WithStack(h.file.Close())
+}
+
+// TraceTransport is an http.RoundTripper that records the requests
+// in a HAR file, and logs them in verbose mode
+type TraceTransport struct {
+	Next    http.RoundTripper
+	HAR     *HAR
+	Verbose bool
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *TraceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var reqBody []byte
+	if t.HAR != nil && req.Body != nil {
+		body, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		reqBody = body
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	started := time.Now()
+	resp, err := t.Next.RoundTrip(req)
+	waited := time.Since(started)
+	if t.Verbose {
+		if err != nil {
+			log.Printf("HTTP %s %s%s failed after %s: %v", req.Method, req.URL.Host, req.URL.Path, waited, err)
+		} else {
+			log.Printf("HTTP %s %s%s %d (%s)", req.Method, req.URL.Host, req.URL.Path, resp.StatusCode, waited)
+		}
+	}
+	if t.HAR == nil {
+		return resp, err
+	}
+	entry := harEntry{
+		StartedDateTime: started,
+		Request:         harRequestFrom(req, reqBody),
+		Response:        harResponse{Cookies: []harNV{}, Headers: []harNV{}},
+		Timings:         harTimings{Wait: millis(waited)},
+	}
+	if err != nil {
+		entry.Time, entry.Comment = millis(waited), Redact(err.Error())
+		t.HAR.add(entry)
+		return nil, err
+	}
+	// Record the body as the caller reads it, the entry is added on Close
+	resp.Body = &harBody{ReadCloser: resp.Body, close: func(body []byte, size int, err error) {
+		received := time.Since(started) - waited
+		entry.Response = harResponseFrom(resp, body, size)
+		entry.Timings.Receive = millis(received)
+		entry.Time = millis(waited + received)
+		switch {
+		case err != nil:
+			entry.Comment = Redact(err.Error())
+		case size > len(body):
+			entry.Comment = fmt.Sprintf("Body truncated to %d bytes", len(body))
+		}
+		t.HAR.add(entry)
+	}}
+	return resp, nil
+}
+
+// harMaxBody is the size of response bodies recorded in the HAR file,
+// longer bodies are truncated
+const harMaxBody = 1 << 20
+
+// harBody keeps a copy of the response body as it is read, up to
+// harMaxBody bytes, and hands it to close when the body is closed
+type harBody struct {
+	io.ReadCloser
+	buffer bytes.Buffer
+	size   int
+	err    error
+	once   sync.Once
+	close  func(body []byte, size int, err error)
+}
+
+// Read implements io.Reader
+func (b *harBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.size += n
+	if room := harMaxBody - b.buffer.Len(); room > 0 {
+		if room > n {
+			room = n
+		}
+		b.buffer.Write(p[:room])
+	}
+	if err != nil && err != io.EOF {
+		b.err = err
+	}
+	return n, err
+}
+
+// Close implements io.Closer
+func (b *harBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() {
+		b.close(b.buffer.Bytes(), b.size, b.err)
+	})
+	return err
+}
+
+func millis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func harRequestFrom(req *http.Request, body []byte) harRequest {
+	query := make([]harNV, 0)
+	for name, values := range req.URL.Query() {
+		for _, value := range values {
+			query = append(query, harNV{Name: name, Value: redactValue(name, value)})
+		}
+	}
+	result := harRequest{
+		Method:      req.Method,
+		URL:         Redact(req.URL.String()),
+		HTTPVersion: req.Proto,
+		Cookies:     harCookies(req.Cookies()),
+		Headers:     harHeaders(req.Header),
+		QueryString: query,
+		HeadersSize: -1,
+		BodySize:    len(body),
+	}
+	if body != nil {
+		mimeType := req.Header.Get("Content-Type")
+		text := string(body)
+		if strings.HasPrefix(mimeType, "application/x-www-form-urlencoded") {
+			text = redactForm(text)
+		}
+		result.PostData = &harPostData{MimeType: mimeType, Text: Redact(text)}
+	}
+	return result
+}
+
+func harResponseFrom(resp *http.Response, body []byte, size int) harResponse {
+	return harResponse{
+		Status:      resp.StatusCode,
+		StatusText:  http.StatusText(resp.StatusCode),
+		HTTPVersion: resp.Proto,
+		Cookies:     harCookies(resp.Cookies()),
+		Headers:     harHeaders(resp.Header),
+		Content: harContent{
+			Size:     size,
+			MimeType: resp.Header.Get("Content-Type"),
+			Text:     Redact(string(body)),
+		},
+		HeadersSize: -1,
+		BodySize:    size,
+	}
+}
+
+func harHeaders(header http.Header) []harNV {
+	result := make([]harNV, 0, len(header))
+	for name, values := range header {
+		for _, value := range values {
+			result = append(result, harNV{Name: name, Value: redactValue(name, value)})
+		}
+	}
+	return result
+}
+
+func harCookies(cookies []*http.Cookie) []harNV {
+	result := make([]harNV, 0, len(cookies))
+	for _, cookie := range cookies {
+		result = append(result, harNV{Name: cookie.Name, Value: Redacted})
+	}
+	return result
+}
+
+// redactValue redacts cookies and secret parameters entirely
+func redactValue(name, value string) string {
+	switch {
+	case strings.EqualFold(name, "Cookie"), strings.EqualFold(name, "Set-Cookie"), strings.EqualFold(name, "Authorization"):
+		return Redacted
+	case secretFields.MatchString(name):
+		return Redacted
+	}
+	return Redact(value)
+}
+
+// redactForm redacts secret fields in a form-encoded body
+func redactForm(text string) string {
+	values, err := url.ParseQuery(text)
+	if err != nil {
+		return Redacted
+	}
+	for name := range values {
+		if secretFields.MatchString(name) {
+			values.Set(name, Redacted)
+		}
+	}
+	return values.Encode()
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// harLog reads the entries recorded in the HAR file
+func harLog(t *testing.T, fname string) []harEntry {
+	data, err := ioutil.ReadFile(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var har struct {
+		Log struct {
+			Entries []harEntry `json:"entries"`
+		} `json:"log"`
+	}
+	if err := json.Unmarshal(data, &har); err != nil {
+		t.Fatal(err)
+	}
+	return har.Log.Entries
+}
+
+func TestTraceTransportStreams(t *testing.T) {
+	tests := []struct {
+		name      string
+		size      int
+		recorded  int
+		truncated bool
+	}{
+		{"small", 100, 100, false},
+		{"large", harMaxBody + 10, harMaxBody, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(strings.Repeat("x", test.size)))
+			}))
+			defer server.Close()
+			dir, err := ioutil.TempDir("", "har")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			fname := filepath.Join(dir, "trace.har")
+			har, err := NewHAR(fname)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer har.Close()
+			client := &http.Client{Transport: &TraceTransport{Next: http.DefaultTransport, HAR: har}}
+			resp, err := client.Get(server.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := resp.Body.(*harBody); !ok {
+				t.Errorf("Body was replaced by %T, not streamed", resp.Body)
+			}
+			if entries := harLog(t, fname); len(entries) != 0 {
+				t.Errorf("Entry recorded before the body was read")
+			}
+			body, err := ioutil.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if len(body) != test.size {
+				t.Errorf("Caller read %d bytes, expected %d", len(body), test.size)
+			}
+			entries := harLog(t, fname)
+			if len(entries) != 1 {
+				t.Fatalf("Recorded %d entries, expected 1", len(entries))
+			}
+			content := entries[0].Response.Content
+			if content.Size != test.size || len(content.Text) != test.recorded {
+				t.Errorf("Recorded size %d and %d bytes, expected %d and %d", content.Size, len(content.Text), test.size, test.recorded)
+			}
+			if truncated := entries[0].Comment != ""; truncated != test.truncated {
+				t.Errorf("Comment is %q, expected truncated %v", entries[0].Comment, test.truncated)
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultNodePath is the config_path used for configuration object
+// tasks on MMs, when their node is not known
+const DefaultNodePath = "/mm/mynode"
+
+// Node is a folder in the configuration hierarchy of the MM
+type Node struct {
+	Name       string   `json:"name"`
+	Type       string   `json:"type"`
+	ChildNodes []Node   `json:"childnodes"`
+	Devices    []Device `json:"devices"`
+}
+
+// Device is a controller attached to a Node
+type Device struct {
+	MAC   string `json:"mac"`
+	Name  string `json:"name"`
+	Model string `json:"model"`
+	Type  string `json:"type"`
+}
+
+// Hierarchy reads the configuration node hierarchy of the MM
+func (c *Controller) Hierarchy() (*Node, error) {
+	if c.useSSH {
+		return nil, errors.New("The node hierarchy can only be read via API, not SSH")
+	}
+	if err := c.Dial(); err != nil {
+		return nil, err
+	}
+	endpoint := "object/node_hierarchy"
+	raw, err := c.apiRaw(http.MethodGet, "/", endpoint, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	op := fmt.Sprintf("GET %s", endpoint)
+	// Depending on the version, the hierarchy may be wrapped in "_data"
+	var wrapped struct {
+		Data struct {
+			Hierarchy *Node `json:"node_hierarchy"`
+		} `json:"_data"`
+	}
+	if err := json.Unmarshal(raw, &wrapped); err != nil {
+		return nil, &DecodeError{MD: c.md, Op: op, Body: string(raw), Err: err}
+	}
+	if wrapped.Data.Hierarchy != nil {
+		return wrapped.Data.Hierarchy, nil
+	}
+	root := &Node{}
+	if err := json.Unmarshal(raw, root); err != nil {
+		return nil, &DecodeError{MD: c.md, Op: op, Body: string(raw), Err: err}
+	}
+	if root.Name == "" {
+		return nil, &DecodeError{MD: c.md, Op: op, Body: string(raw), Err: errors.New("Missing root node")}
+	}
+	return root, nil
+}
+
+// Walk calls f for every node in the tree, with its full path
+// (e.g. "/md/EMEA/Madrid")
+func (n *Node) Walk(f func(nodePath string, node *Node)) {
+	n.walk("", f)
+}
+
+func (n *Node) walk(parent string, f func(nodePath string, node *Node)) {
+	nodePath := "/"
+	if n.Name != "/" && n.Name != "" {
+		nodePath = path.Join("/", parent, n.Name)
+	}
+	f(nodePath, n)
+	for i := range n.ChildNodes {
+		n.ChildNodes[i].walk(nodePath, f)
+	}
+}
+
+// AssignNodes sets the node path and MAC of the switches, matching
+// the devices in the hierarchy by name. Returns the names of the
+// switches not found in the hierarchy, and of the switches whose name
+// is used by several devices. The latter are left unassigned, their
+// node can't be told.
+func AssignNodes(switches []Switch, root *Node) (missing, ambiguous []string) {
+	type location struct {
+		nodePath string
+		mac      string
+	}
+	byName := make(map[string][]location)
+	root.Walk(func(nodePath string, node *Node) {
+		for _, device := range node.Devices {
+			name := strings.ToLower(device.Name)
+			byName[name] = append(byName[name], location{nodePath: nodePath, mac: device.MAC})
+		}
+	})
+	missing, ambiguous = make([]string, 0), make([]string, 0)
+	for i, sw := range switches {
+		found := byName[strings.ToLower(sw.Name)]
+		switch {
+		case len(found) <= 0 || sw.Name == "":
+			missing = append(missing, sw.Label())
+		case len(found) > 1:
+			ambiguous = append(ambiguous, sw.Label())
+		default:
+			switches[i].Node, switches[i].MAC = found[0].nodePath, found[0].mac
+		}
+	}
+	return missing, ambiguous
+}
+
+// UnderNodes returns the switches attached to any of the given node
+// paths, or their subtrees
+func UnderNodes(switches []Switch, nodePaths []string) []Switch {
+	selected := make([]Switch, 0, len(switches))
+	for _, sw := range switches {
+		for _, nodePath := range nodePaths {
+			if isUnder(sw.Node, nodePath) {
+				selected = append(selected, sw)
+				break
+			}
+		}
+	}
+	return selected
+}
+
+// isUnder returns true if nodePath is root, or inside the tree of root
+func isUnder(nodePath, root string) bool {
+	if nodePath == "" {
+		return false
+	}
+	root = path.Clean("/" + root)
+	if root == "/" || nodePath == root {
+		return true
+	}
+	return strings.HasPrefix(nodePath, root+"/")
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAssignNodes(t *testing.T) {
+	root := &Node{Name: "/", ChildNodes: []Node{
+		{Name: "md", ChildNodes: []Node{
+			{Name: "Madrid", Devices: []Device{{Name: "md1", MAC: "00:00:00:00:00:01"}, {Name: "md-dup", MAC: "00:00:00:00:00:02"}}},
+			{Name: "Barcelona", Devices: []Device{{Name: "MD-DUP", MAC: "00:00:00:00:00:03"}}},
+		}},
+	}}
+	switches := []Switch{
+		{IP: "10.0.0.1", Name: "MD1"},
+		{IP: "10.0.0.2", Name: "md-dup"},
+		{IP: "10.0.0.3", Name: "md-other"},
+		{IP: "10.0.0.4"},
+	}
+	missing, ambiguous := AssignNodes(switches, root)
+	if switches[0].Node != "/md/Madrid" || switches[0].MAC != "00:00:00:00:00:01" {
+		t.Errorf("Switch assigned to node %q with MAC %q", switches[0].Node, switches[0].MAC)
+	}
+	if switches[1].Node != "" || switches[1].MAC != "" {
+		t.Errorf("Duplicate name assigned to node %q with MAC %q", switches[1].Node, switches[1].MAC)
+	}
+	if expected := []string{switches[2].Label(), switches[3].Label()}; !reflect.DeepEqual(missing, expected) {
+		t.Errorf("Missing %v, expected %v", missing, expected)
+	}
+	if expected := []string{switches[1].Label()}; !reflect.DeepEqual(ambiguous, expected) {
+		t.Errorf("Ambiguous %v, expected %v", ambiguous, expected)
+	}
+}
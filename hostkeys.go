@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Host key verification modes
+const (
+	// HostKeyIgnore accepts any host key, without checking
+	HostKeyIgnore = "ignore"
+	// HostKeyTOFU trusts and records the keys of unknown hosts,
+	// and fails if the key of a known host does not match
+	HostKeyTOFU = "tofu"
+	// HostKeyStrict fails if the host is unknown or the key does not match
+	HostKeyStrict = "strict"
+)
+
+// HostKeys verifies SSH host keys against a known_hosts file
+type HostKeys struct {
+	mode  string
+	file  string
+	mutex sync.Mutex
+	check ssh.HostKeyCallback
+}
+
+// DefaultKnownHosts returns the path of the user's OpenSSH known_hosts file
+func DefaultKnownHosts() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "known_hosts"
+	}
+	return filepath.Join(home, ".ssh", "known_hosts")
+}
+
+// NewHostKeys loads the known_hosts file for the given verification mode
+func NewHostKeys(mode, file string) (*HostKeys, error) {
+	h := &HostKeys{mode: mode, file: file}
+	switch mode {
+	case HostKeyIgnore:
+		return h, nil
+	case HostKeyTOFU:
+		// Make sure the file exists, so new keys can be added
+		if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+			return nil, errors.Wrapf(err, "Failed to create folder for '%s'", file)
+		}
+		f, err := os.OpenFile(file, os.O_CREATE|os.O_RDONLY, 0600)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to open known hosts file '%s'", file)
+		}
+		f.Close()
+	case HostKeyStrict:
+	default:
+		return nil, errors.Errorf("Unknown host key mode '%s', must be one of '%s', '%s' or '%s'", mode, HostKeyIgnore, HostKeyTOFU, HostKeyStrict)
+	}
+	if err := h.reload(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// Mode returns the verification mode
+func (h *HostKeys) Mode() string {
+	if h == nil {
+		return HostKeyIgnore
+	}
+	return h.mode
+}
+
+// reload the known_hosts file. Must be called with the mutex held.
+func (h *HostKeys) reload() error {
+	check, err := knownhosts.New(h.file)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to load known hosts file '%s'", h.file)
+	}
+	h.check = check
+	return nil
+}
+
+// Callback returns a HostKeyCallback for ssh.ClientConfig
+func (h *HostKeys) Callback() ssh.HostKeyCallback {
+	if h.Mode() == HostKeyIgnore {
+		return ssh.InsecureIgnoreHostKey()
+	}
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		h.mutex.Lock()
+		defer h.mutex.Unlock()
+		unknown, err := h.verify(hostname, remote, key)
+		if unknown && h.mode == HostKeyTOFU {
+			log.Printf("Trusting new SSH host key for '%s' (%s)", hostname, ssh.FingerprintSHA256(key))
+			return h.add(hostname, key)
+		}
+		return err
+	}
+}
+
+// verify the key, returns true if the host is not in the file.
+// Must be called with the mutex held.
+func (h *HostKeys) verify(hostname string, remote net.Addr, key ssh.PublicKey) (bool, error) {
+	err := h.check(hostname, remote, key)
+	if err == nil {
+		return false, nil
+	}
+	var keyErr *knownhosts.KeyError
+	if errors.As(err, &keyErr) {
+		if len(keyErr.Want) == 0 {
+			return true, errors.Errorf("Unknown SSH host key for '%s' (%s) in '%s'", hostname, ssh.FingerprintSHA256(key), h.file)
+		}
+		return false, errors.Errorf("SSH host key mismatch for '%s': got %s, expected %s at %s:%d", hostname,
+			ssh.FingerprintSHA256(key), ssh.FingerprintSHA256(keyErr.Want[0].Key), keyErr.Want[0].Filename, keyErr.Want[0].Line)
+	}
+	return false, errors.Wrapf(err, "Failed to verify SSH host key for '%s'", hostname)
+}
+
+// add a key to the file, and reload. Must be called with the mutex held.
+func (h *HostKeys) add(hostname string, key ssh.PublicKey) error {
+	f, err := os.OpenFile(h.file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to open known hosts file '%s'", h.file)
+	}
+	line := knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key)
+	if _, err := fmt.Fprintln(f, line); err != nil {
+		f.Close()
+		return errors.Wrapf(err, "Failed to add host key to '%s'", h.file)
+	}
+	if err := f.Close(); err != nil {
+		return errors.Wrapf(err, "Failed to close known hosts file '%s'", h.file)
+	}
+	return h.reload()
+}
+
+// errKeyScanned aborts the SSH handshake once the host key is received
+var errKeyScanned = errors.New("host key scanned")
+
+// Scan connects to the SSH server at addr and records its host key,
+// if it is not already known. Fails if the host key does not match.
+func (h *HostKeys) Scan(dialer Dialer, addr string) error {
+	var scanned error
+	config := &ssh.ClientConfig{
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			h.mutex.Lock()
+			defer h.mutex.Unlock()
+			unknown, err := h.verify(hostname, remote, key)
+			switch {
+			case unknown:
+				log.Printf("Adding SSH host key for '%s' (%s)", hostname, ssh.FingerprintSHA256(key))
+				scanned = h.add(hostname, key)
+			case err != nil:
+				scanned = err
+			default:
+				log.Printf("SSH host key for '%s' already known (%s)", hostname, ssh.FingerprintSHA256(key))
+			}
+			return errKeyScanned
+		},
+	}
+	client, err := sshDial(dialer, addr, config)
+	if err == nil {
+		// Should not happen, the callback always aborts the handshake
+		client.Close()
+		return nil
+	}
+	if !errors.Is(err, errKeyScanned) {
+		return errors.Wrapf(err, "Failed to scan SSH host key of '%s'", addr)
+	}
+	return scanned
+}
@@ -34,7 +34,11 @@ func (l Lookups) Lookup(data interface{}) (interface{}, error) {
 		}
 		result, err := lookup.Lookup(data)
 		if err != nil {
-			return nil, err
+			var filterErr *FilterError
+			if errors.As(err, &filterErr) {
+				return nil, err
+			}
+			return nil, &FilterError{Filter: fmt.Sprint(lookup), Err: err}
 		}
 		data = result
 	}
@@ -58,8 +62,8 @@ type jsonLookup struct {
 	*jsonpath.Compiled
 }
 
-func (jsonLookup) ForSSH() (string, error) {
-	return "", errors.New("JSON filter not applicable as SSH filter")
+func (l jsonLookup) ForSSH() (string, error) {
+	return "", &FilterError{Filter: l.String(), Err: errors.New("JSON filter not applicable as SSH filter")}
 }
 
 // NewLookup turns a chain of filters into a list of Lookups
@@ -88,7 +92,7 @@ func NewLookup(chain string) (Lookups, error) {
 		}
 		compiled, err := jsonpath.Compile(filter)
 		if err != nil {
-			return nil, errors.Wrapf(err, "Failed to compile filter '%s'", filter)
+			return nil, &FilterError{Filter: filter, Err: errors.Wrap(err, "Failed to compile filter")}
 		}
 		result = append(result, jsonLookup{compiled})
 	}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Object is a configuration object of the AOS REST API
+type Object interface {
+	// Name of the object, e.g. "flash_backup" for "object/flash_backup"
+	Name() string
+	// Validate checks the object before it is sent
+	Validate() error
+}
+
+// objectTypes builds an empty object for each supported name
+var objectTypes = map[string]func() Object{
+	"flash_backup":    func() Object { return &FlashBackup{} },
+	"copy_flash_scp":  func() Object { return &CopyFlashSCP{} },
+	"aaa_user_add":    func() Object { return &AAAUserAdd{} },
+	"aaa_user_delete": func() Object { return &AAAUserDelete{} },
+	"mgmt_user":       func() Object { return &MgmtUser{} },
+	"write_memory":    func() Object { return &WriteMemory{} },
+	"role":            func() Object { return &Role{} },
+	"acl_sess":        func() Object { return &ACLSession{} },
+	"acl_std":         func() Object { return &ACLStandard{} },
+	"acl_eth":         func() Object { return &ACLEthertype{} },
+	"acl_mac":         func() Object { return &ACLMAC{} },
+}
+
+// NewObject decodes data into the typed object with the given name,
+// rejecting unknown attributes.
+func NewObject(name string, data interface{}) (Object, error) {
+	factory, ok := objectTypes[strings.TrimPrefix(name, "object/")]
+	if !ok {
+		return nil, errors.Errorf("Unknown configuration object '%s'", name)
+	}
+	obj := factory()
+	if data == nil {
+		return obj, nil
+	}
+	marshaled, err := json.Marshal(data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to marshal data for object '%s'", name)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(marshaled))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(obj); err != nil {
+		return nil, errors.Wrapf(err, "Invalid data for object '%s'", name)
+	}
+	return obj, nil
+}
+
+// FlashBackup runs a backup of the flash ("backup flash")
+type FlashBackup struct {
+	BackupFlash string `json:"backup_flash"`
+	// Base name of the backup file, without suffix. See CapBackupFilename.
+	Filename string `json:"filename,omitempty"`
+}
+
+// Name implements Object
+func (o *FlashBackup) Name() string { return "flash_backup" }
+
+// Validate implements Object
+func (o *FlashBackup) Validate() error {
+	if o.BackupFlash != "flash" {
+		return errors.Errorf("flash_backup: backup_flash must be 'flash', not '%s'", o.BackupFlash)
+	}
+	return nil
+}
+
+// CopyFlashSCP copies a file from flash to a SCP server
+type CopyFlashSCP struct {
+	SCPHost      string `json:"scphost"`
+	Username     string `json:"username"`
+	Passwd       string `json:"passwd"`
+	SrcFilename  string `json:"srcfilename"`
+	DestFilename string `json:"destfilename"`
+}
+
+// Name implements Object
+func (o *CopyFlashSCP) Name() string { return "copy_flash_scp" }
+
+// Validate implements Object
+func (o *CopyFlashSCP) Validate() error {
+	return required("copy_flash_scp",
+		attribute{"scphost", o.SCPHost},
+		attribute{"username", o.Username},
+		attribute{"passwd", o.Passwd},
+		attribute{"srcfilename", o.SrcFilename},
+		attribute{"destfilename", o.DestFilename},
+	)
+}
+
+// AAAUserAdd adds a user to the user table ("aaa user add")
+type AAAUserAdd struct {
+	IPAddr   string `json:"ipaddr"`
+	MACAddr  string `json:"macaddr,omitempty"`
+	Role     string `json:"role"`
+	UserName string `json:"name,omitempty"`
+}
+
+// Name implements Object
+func (o *AAAUserAdd) Name() string { return "aaa_user_add" }
+
+// Validate implements Object
+func (o *AAAUserAdd) Validate() error {
+	return required("aaa_user_add", attribute{"ipaddr", o.IPAddr}, attribute{"role", o.Role})
+}
+
+// AAAUserDelete removes users from the user table ("aaa user delete")
+type AAAUserDelete struct {
+	IPAddr   string `json:"ipaddr,omitempty"`
+	MACAddr  string `json:"macaddr,omitempty"`
+	UserName string `json:"name,omitempty"`
+	Role     string `json:"role,omitempty"`
+	All      bool   `json:"all,omitempty"`
+}
+
+// Name implements Object
+func (o *AAAUserDelete) Name() string { return "aaa_user_delete" }
+
+// Validate implements Object
+func (o *AAAUserDelete) Validate() error {
+	if o.IPAddr == "" && o.MACAddr == "" && o.UserName == "" && o.Role == "" && !o.All {
+		return errors.New("aaa_user_delete: one of ipaddr, macaddr, name, role or all is required")
+	}
+	return nil
+}
+
+// MgmtUser is a management user of the controller ("mgmt-user")
+type MgmtUser struct {
+	UsrName string `json:"usrname"`
+	Passwd  string `json:"passwd,omitempty"`
+	Role    string `json:"role"`
+}
+
+// Name implements Object
+func (o *MgmtUser) Name() string { return "mgmt_user" }
+
+// Validate implements Object
+func (o *MgmtUser) Validate() error {
+	return required("mgmt_user", attribute{"usrname", o.UsrName}, attribute{"role", o.Role})
+}
+
+// WriteMemory saves the configuration ("write memory")
+type WriteMemory struct{}
+
+// Name implements Object
+func (o *WriteMemory) Name() string { return "write_memory" }
+
+// Validate implements Object
+func (o *WriteMemory) Validate() error { return nil }
+
+// RoleACL is an ACL applied to a user role
+type RoleACL struct {
+	ACLType string `json:"acl_type"`
+	PName   string `json:"pname"`
+}
+
+// Role is a user role
+type Role struct {
+	RName   string    `json:"rname"`
+	RoleACL []RoleACL `json:"role__acl,omitempty"`
+}
+
+// Name implements Object
+func (o *Role) Name() string { return "role" }
+
+// Validate implements Object
+func (o *Role) Validate() error {
+	if err := required("role", attribute{"rname", o.RName}); err != nil {
+		return err
+	}
+	for _, acl := range o.RoleACL {
+		if err := required("role__acl", attribute{"acl_type", acl.ACLType}, attribute{"pname", acl.PName}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ACLSession is a session ACL. Policy entries are kept untyped,
+// they have too many variants.
+type ACLSession struct {
+	AccName  string                   `json:"accname"`
+	V4Policy []map[string]interface{} `json:"acl_sess__v4policy,omitempty"`
+	V6Policy []map[string]interface{} `json:"acl_sess__v6policy,omitempty"`
+}
+
+// Name implements Object
+func (o *ACLSession) Name() string { return "acl_sess" }
+
+// Validate implements Object
+func (o *ACLSession) Validate() error {
+	return required("acl_sess", attribute{"accname", o.AccName})
+}
+
+// ACLStandard is a standard ACL. Entries are kept untyped, like in ACLSession.
+type ACLStandard struct {
+	AccName string                   `json:"accname"`
+	Entries []map[string]interface{} `json:"acl_std__entry,omitempty"`
+}
+
+// Name implements Object
+func (o *ACLStandard) Name() string { return "acl_std" }
+
+// Validate implements Object
+func (o *ACLStandard) Validate() error {
+	return required("acl_std", attribute{"accname", o.AccName})
+}
+
+// ACLEthertype is an Ethertype ACL. Entries are kept untyped.
+type ACLEthertype struct {
+	AccName string                   `json:"accname"`
+	Entries []map[string]interface{} `json:"acl_eth__entry,omitempty"`
+}
+
+// Name implements Object
+func (o *ACLEthertype) Name() string { return "acl_eth" }
+
+// Validate implements Object
+func (o *ACLEthertype) Validate() error {
+	return required("acl_eth", attribute{"accname", o.AccName})
+}
+
+// ACLMAC is a MAC ACL. Entries are kept untyped.
+type ACLMAC struct {
+	AccName string                   `json:"accname"`
+	Entries []map[string]interface{} `json:"acl_mac__entry,omitempty"`
+}
+
+// Name implements Object
+func (o *ACLMAC) Name() string { return "acl_mac" }
+
+// Validate implements Object
+func (o *ACLMAC) Validate() error {
+	return required("acl_mac", attribute{"accname", o.AccName})
+}
+
+// attribute is the name and value of a required attribute
+type attribute struct {
+	name  string
+	value string
+}
+
+// required checks that the attributes are not empty. Missing
+// attributes are reported in the given order.
+func required(name string, attribs ...attribute) error {
+	missing := make([]string, 0, len(attribs))
+	for _, attrib := range attribs {
+		if attrib.value == "" {
+			missing = append(missing, attrib.name)
+		}
+	}
+	if len(missing) > 0 {
+		return errors.Errorf("%s: missing required attributes '%s'", name, strings.Join(missing, "', '"))
+	}
+	return nil
+}
+
+// PostObject validates and sends a configuration object, and checks
+// the "_global_result" of the response. Rejected unless allowed by
+// the profile write policy.
+func (c *Controller) PostObject(cfgPath string, obj Object) (*GlobalResult, error) {
+	if err := c.profile.Writes.CheckPost(c.md, "object/"+obj.Name()); err != nil {
+		return nil, err
+	}
+	return c.postObject(cfgPath, obj)
+}
+
+// postObject sends the object without checking the write policy,
+// for operations explicitly requested in the command line (e.g. backup)
+func (c *Controller) postObject(cfgPath string, obj Object) (*GlobalResult, error) {
+	if err := obj.Validate(); err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(obj)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to marshal object '%s'", obj.Name())
+	}
+	endpoint := "object/" + obj.Name()
+	raw, err := c.apiRaw(http.MethodPost, cfgPath, endpoint, nil, body)
+	if err != nil {
+		return nil, err
+	}
+	op := fmt.Sprintf("POST %s (config_path %s)", endpoint, cfgPath)
+	var response struct {
+		GlobalResult *GlobalResult `json:"_global_result"`
+	}
+	if err := json.Unmarshal(raw, &response); err != nil {
+		return nil, &DecodeError{MD: c.md, Op: op, Body: string(raw), Err: err}
+	}
+	if response.GlobalResult == nil {
+		return nil, &DecodeError{MD: c.md, Op: op, Body: string(raw), Err: errors.New("Missing _global_result")}
+	}
+	if response.GlobalResult.Status != 0 {
+		return response.GlobalResult, &APIStatusError{MD: c.md, Op: op, StatusCode: http.StatusOK, GlobalResult: response.GlobalResult}
+	}
+	return response.GlobalResult, nil
+}
+
+// GetObjects reads the instances of a configuration object at the
+// config path into target, which must be a pointer to a slice
+// (e.g. *[]Role for "role"). Large objects are read page by page.
+func (c *Controller) GetObjects(cfgPath, name string, target interface{}) error {
+	return c.eachInto(cfgPath, "object/"+name, target)
+}
+
+// Roles returns the user roles at the config path
+func (c *Controller) Roles(cfgPath string) ([]Role, error) {
+	var roles []Role
+	err := c.GetObjects(cfgPath, "role", &roles)
+	return roles, err
+}
+
+// ACLSessions returns the session ACLs at the config path
+func (c *Controller) ACLSessions(cfgPath string) ([]ACLSession, error) {
+	var acls []ACLSession
+	err := c.GetObjects(cfgPath, "acl_sess", &acls)
+	return acls, err
+}
+
+// MgmtUsers returns the management users at the config path
+func (c *Controller) MgmtUsers(cfgPath string) ([]MgmtUser, error) {
+	var users []MgmtUser
+	err := c.GetObjects(cfgPath, "mgmt_user", &users)
+	return users, err
+}
+
+// UserTableEntry is a row of the user table ("show user-table")
+type UserTableEntry struct {
+	IP       string `json:"IP"`
+	MAC      string `json:"MAC"`
+	Name     string `json:"Name"`
+	Role     string `json:"Role"`
+	Auth     string `json:"Auth"`
+	APName   string `json:"AP_name"`
+	Profile  string `json:"Profile"`
+	Type     string `json:"Type"`
+	HostName string `json:"Host_Name"`
+}
+
+// UserTable returns the users of the controller ("show user-table")
+func (c *Controller) UserTable() ([]UserTableEntry, error) {
+	if c.useSSH {
+		return nil, errors.New("The user table can only be read via API, not SSH")
+	}
+	data, err := c.Show("show user-table", nil)
+	if err != nil {
+		return nil, err
+	}
+	var table struct {
+		Users []UserTableEntry `json:"Users"`
+	}
+	marshaled, err := json.Marshal(data)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to marshal user table")
+	}
+	if err := json.Unmarshal(marshaled, &table); err != nil {
+		return nil, &DecodeError{MD: c.md, Op: "show user-table", Body: string(marshaled), Err: err}
+	}
+	return table.Users, nil
+}
+
+// WriteMemory saves the configuration at the config path
+func (c *Controller) WriteMemory(cfgPath string) error {
+	_, err := c.PostObject(cfgPath, &WriteMemory{})
+	return err
+}
@@ -0,0 +1,40 @@
+package main
+
+import "testing"
+
+func TestRequiredOrder(t *testing.T) {
+	obj := &CopyFlashSCP{Username: "admin"}
+	expected := "copy_flash_scp: missing required attributes 'scphost', 'passwd', 'srcfilename', 'destfilename'"
+	for i := 0; i < 10; i++ {
+		if err := obj.Validate(); err == nil || err.Error() != expected {
+			t.Fatalf("Validate returned '%v', expected '%s'", err, expected)
+		}
+	}
+}
+
+func TestNewObject(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  map[string]interface{}
+		valid bool
+	}{
+		{"aaa_user_add", map[string]interface{}{"ipaddr": "10.0.0.1", "role": "guest"}, true},
+		{"aaa_user_add", map[string]interface{}{"ipaddr": "10.0.0.1"}, false},
+		{"object/aaa_user_delete", map[string]interface{}{"ipaddr": "10.0.0.1"}, true},
+		{"aaa_user_delete", map[string]interface{}{}, false},
+		{"mgmt_user", map[string]interface{}{"usrname": "ops", "role": "read-only"}, true},
+		{"acl_std", map[string]interface{}{"accname": "10", "acl_std__entry": []interface{}{map[string]interface{}{"any": true}}}, true},
+		{"acl_mac", map[string]interface{}{}, false},
+		{"role", map[string]interface{}{"rname": "guest", "typo": 1}, false},
+		{"unknown", nil, false},
+	}
+	for _, test := range tests {
+		obj, err := NewObject(test.name, test.data)
+		if err == nil {
+			err = obj.Validate()
+		}
+		if (err == nil) != test.valid {
+			t.Errorf("Object %s %v: got error '%v', expected valid %v", test.name, test.data, err, test.valid)
+		}
+	}
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultPageSize is the number of instances requested per page
+// when reading configuration objects
+const DefaultPageSize = 500
+
+// objectName returns the name of the configuration object for an
+// "object/<name>" endpoint, or "" if the endpoint is not an object.
+func objectName(endpoint string) string {
+	endpoint = strings.TrimPrefix(endpoint, "/")
+	if !strings.HasPrefix(endpoint, "object/") {
+		return ""
+	}
+	return strings.TrimPrefix(endpoint, "object/")
+}
+
+// Each reads the instances of a configuration object ("object/<name>")
+// page by page, and calls each for every instance. Only one page is kept
+// in memory at a time. If the params already include "offset" or "limit",
+// or paging is disabled in the profile, a single request is issued.
+// Returning io.EOF from each stops the iteration without error.
+func (c *Controller) Each(cfgPath, endpoint string, params map[string]string, each func(json.RawMessage) error) error {
+	_, err := c.eachPage(cfgPath, endpoint, params, each)
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
+
+// eachPage implements Each, and returns the first page without its
+// instances, so callers can rebuild the response. io.EOF from each
+// is returned as is.
+func (c *Controller) eachPage(cfgPath, endpoint string, params map[string]string, each func(json.RawMessage) error) (*objectPage, error) {
+	name := objectName(endpoint)
+	if name == "" {
+		return nil, errors.Errorf("Endpoint '%s' is not a configuration object", endpoint)
+	}
+	size := c.profile.PageSize
+	_, hasOffset := params["offset"]
+	_, hasLimit := params["limit"]
+	if hasOffset || hasLimit {
+		size = 0
+	}
+	var head *objectPage
+	var previous json.RawMessage
+	for offset := 0; ; {
+		current, err := c.objectPage(cfgPath, endpoint, name, params, offset, size)
+		if err != nil {
+			return head, err
+		}
+		if head == nil {
+			head = current
+		}
+		items := current.items
+		if size > 0 && offset > 0 && len(items) > 0 && bytes.Equal(items[0], previous) {
+			// The controller ignores the offset, fall back to a single request.
+			// Items are expected in the same order, skip those already delivered.
+			if current, err = c.objectPage(cfgPath, endpoint, name, params, 0, 0); err != nil {
+				return head, err
+			}
+			return head, deliver(current.items, offset, each)
+		}
+		if size > 0 && len(items) > size {
+			// The controller ignores the limit, this is the single response
+			return head, deliver(items, offset, each)
+		}
+		// Items are delivered only once the page is complete,
+		// so retries never yield duplicates.
+		if err := deliver(items, 0, each); err != nil {
+			return head, err
+		}
+		if len(items) > 0 {
+			previous = items[0]
+		}
+		offset += len(items)
+		if size <= 0 || current.single || len(items) < size || (current.total >= 0 && offset >= current.total) {
+			return head, nil
+		}
+	}
+}
+
+// objectPage requests a page of instances, or all of them if size is 0
+func (c *Controller) objectPage(cfgPath, endpoint, name string, params map[string]string, offset, size int) (*objectPage, error) {
+	query := make(map[string]string, len(params)+2)
+	for k, v := range params {
+		query[k] = v
+	}
+	if size > 0 {
+		query["offset"] = strconv.Itoa(offset)
+		query["limit"] = strconv.Itoa(size)
+	}
+	var current *objectPage
+	op := fmt.Sprintf("GET %s (config_path %s, offset %d)", endpoint, cfgPath, offset)
+	err := c.apiStream(http.MethodGet, cfgPath, endpoint, query, nil, func(r io.Reader) error {
+		// Start from scratch if the request is retried
+		current = &objectPage{}
+		if err := current.decode(r, name); err != nil {
+			return &DecodeError{MD: c.md, Op: op, Err: err}
+		}
+		return nil
+	})
+	return current, err
+}
+
+// deliver calls each for the items, skipping the first ones
+func deliver(items []json.RawMessage, skip int, each func(json.RawMessage) error) error {
+	if skip >= len(items) {
+		return nil
+	}
+	for _, item := range items[skip:] {
+		if err := each(item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// objectPage is a page of instances of a configuration object
+type objectPage struct {
+	items []json.RawMessage
+	// Total number of instances, if reported by the API. Otherwise -1.
+	total int
+	// True if "_data.<name>" is a single instance, not a list
+	single bool
+	// Other attributes of the response, and of "_data"
+	other map[string]json.RawMessage
+	data  map[string]json.RawMessage
+}
+
+// decode reads the page from the response body, one instance at a time.
+// The instances are expected in "_data.<name>", and the total count in
+// "_total" or "_meta.total". Other attributes are kept as they are.
+func (p *objectPage) decode(r io.Reader, name string) error {
+	p.total = -1
+	p.other, p.data = make(map[string]json.RawMessage), make(map[string]json.RawMessage)
+	decoder := json.NewDecoder(r)
+	return decodeObject(decoder, func(key string) error {
+		if key == "_data" {
+			return decodeObject(decoder, func(key string) error {
+				if key != name {
+					return decodeRaw(decoder, p.data, key)
+				}
+				return p.decodeItems(decoder)
+			})
+		}
+		if err := decodeRaw(decoder, p.other, key); err != nil {
+			return err
+		}
+		switch key {
+		case "_total":
+			var total int
+			if err := json.Unmarshal(p.other[key], &total); err == nil {
+				p.total = total
+			}
+		case "_meta":
+			var meta struct {
+				Total *int `json:"total"`
+			}
+			if err := json.Unmarshal(p.other[key], &meta); err == nil && meta.Total != nil {
+				p.total = *meta.Total
+			}
+		}
+		return nil
+	})
+}
+
+// decodeItems reads the instances, streaming them if they are a list.
+// Anything else is a single instance, or none if null.
+func (p *objectPage) decodeItems(decoder *json.Decoder) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	switch token {
+	case nil:
+		p.single = true
+		return nil
+	case json.Delim('['):
+		for decoder.More() {
+			var item json.RawMessage
+			if err := decoder.Decode(&item); err != nil {
+				return err
+			}
+			p.items = append(p.items, item)
+		}
+		return expectDelim(decoder, ']')
+	case json.Delim('{'):
+		// A single instance, rebuild it from its attributes
+		attrs := make(map[string]json.RawMessage)
+		for decoder.More() {
+			token, err := decoder.Token()
+			if err != nil {
+				return err
+			}
+			key, ok := token.(string)
+			if !ok {
+				return errors.Errorf("Expected object key, found '%v'", token)
+			}
+			if err := decodeRaw(decoder, attrs, key); err != nil {
+				return err
+			}
+		}
+		if err := expectDelim(decoder, '}'); err != nil {
+			return err
+		}
+		item, err := json.Marshal(attrs)
+		if err != nil {
+			return err
+		}
+		p.items, p.single = []json.RawMessage{item}, true
+		return nil
+	}
+	if _, ok := token.(json.Delim); ok {
+		return errors.Errorf("Unexpected '%v'", token)
+	}
+	item, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+	p.items, p.single = []json.RawMessage{item}, true
+	return nil
+}
+
+// decodeRaw stores the next value in values, under key
+func decodeRaw(decoder *json.Decoder, values map[string]json.RawMessage, key string) error {
+	var raw json.RawMessage
+	if err := decoder.Decode(&raw); err != nil {
+		return err
+	}
+	values[key] = raw
+	return nil
+}
+
+// decodeObject walks the keys of a JSON object. field must consume the value.
+func decodeObject(decoder *json.Decoder, field func(key string) error) error {
+	if err := expectDelim(decoder, '{'); err != nil {
+		return err
+	}
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		key, ok := token.(string)
+		if !ok {
+			return errors.Errorf("Expected object key, found '%v'", token)
+		}
+		if err := field(key); err != nil {
+			return err
+		}
+	}
+	return expectDelim(decoder, '}')
+}
+
+func expectDelim(decoder *json.Decoder, expected json.Delim) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != expected {
+		return errors.Errorf("Expected '%v', found '%v'", expected, token)
+	}
+	return nil
+}
+
+// getPaged reads all the instances of a configuration object, and
+// returns them with the same shape as a single API response. All of
+// them are kept in memory, use Each to stream large objects.
+func (c *Controller) getPaged(cfgPath, endpoint string, params map[string]string) (interface{}, error) {
+	items := make([]interface{}, 0)
+	op := fmt.Sprintf("GET %s (config_path %s)", endpoint, cfgPath)
+	head, err := c.eachPage(cfgPath, endpoint, params, func(raw json.RawMessage) error {
+		var item interface{}
+		if err := json.Unmarshal(raw, &item); err != nil {
+			return &DecodeError{MD: c.md, Op: op, Body: string(raw), Err: err}
+		}
+		items = append(items, item)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]interface{}, len(head.other)+1)
+	for key, raw := range head.other {
+		var value interface{}
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return nil, &DecodeError{MD: c.md, Op: op, Body: string(raw), Err: err}
+		}
+		result[key] = value
+	}
+	data := make(map[string]interface{}, len(head.data)+1)
+	for key, raw := range head.data {
+		var value interface{}
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return nil, &DecodeError{MD: c.md, Op: op, Body: string(raw), Err: err}
+		}
+		data[key] = value
+	}
+	switch {
+	case head.single && len(items) > 0:
+		data[objectName(endpoint)] = items[0]
+	case head.single:
+		data[objectName(endpoint)] = nil
+	default:
+		data[objectName(endpoint)] = items
+	}
+	result["_data"] = data
+	return noWhitespace(result, c.reg), nil
+}
+
+// eachInto appends every instance of the object to target, which must
+// be a pointer to a slice
+func (c *Controller) eachInto(cfgPath, endpoint string, target interface{}) error {
+	slice := reflect.ValueOf(target)
+	if slice.Kind() != reflect.Ptr || slice.Elem().Kind() != reflect.Slice {
+		return errors.Errorf("Target must be a pointer to a slice, not %T", target)
+	}
+	slice = slice.Elem()
+	op := fmt.Sprintf("GET %s (config_path %s)", endpoint, cfgPath)
+	return c.Each(cfgPath, endpoint, nil, func(raw json.RawMessage) error {
+		item := reflect.New(slice.Type().Elem())
+		if err := json.Unmarshal(raw, item.Interface()); err != nil {
+			return &DecodeError{MD: c.md, Op: op, Body: string(raw), Err: err}
+		}
+		slice.Set(reflect.Append(slice, item.Elem()))
+		return nil
+	})
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestObjectPageDecode(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		items  []string
+		total  int
+		single bool
+		other  []string
+	}{
+		{
+			name:  "list with meta total",
+			body:  `{"_data":{"user":[{"a":1},{"a":2}]},"_meta":{"total":5},"_global_result":{"status":0}}`,
+			items: []string{`{"a":1}`, `{"a":2}`},
+			total: 5,
+			other: []string{"_global_result", "_meta"},
+		},
+		{
+			name:  "list with top level total",
+			body:  `{"_total":3,"_data":{"user":[{"a":1}]}}`,
+			items: []string{`{"a":1}`},
+			total: 3,
+			other: []string{"_total"},
+		},
+		{
+			name:  "missing total",
+			body:  `{"_data":{"user":[]}}`,
+			total: -1,
+		},
+		{
+			name:   "single instance",
+			body:   `{"_data":{"user":{"a":1,"b":"x"}}}`,
+			items:  []string{`{"a":1,"b":"x"}`},
+			total:  -1,
+			single: true,
+		},
+		{
+			name:   "null",
+			body:   `{"_data":{"user":null}}`,
+			total:  -1,
+			single: true,
+		},
+		{
+			name:  "other objects in data",
+			body:  `{"_data":{"other":[1,2],"user":[{"a":1}]}}`,
+			items: []string{`{"a":1}`},
+			total: -1,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var page objectPage
+			if err := page.decode(strings.NewReader(test.body), "user"); err != nil {
+				t.Fatal(err)
+			}
+			if len(page.items) != len(test.items) {
+				t.Fatalf("Got %d items, expected %d", len(page.items), len(test.items))
+			}
+			for i, item := range page.items {
+				if string(item) != test.items[i] {
+					t.Errorf("Item %d is %s, expected %s", i, item, test.items[i])
+				}
+			}
+			if page.total != test.total {
+				t.Errorf("Total is %d, expected %d", page.total, test.total)
+			}
+			if page.single != test.single {
+				t.Errorf("Single is %v, expected %v", page.single, test.single)
+			}
+			for _, key := range test.other {
+				if _, ok := page.other[key]; !ok {
+					t.Errorf("Attribute %s not kept", key)
+				}
+			}
+		})
+	}
+}
+
+func TestObjectPageDecodeErrors(t *testing.T) {
+	for _, body := range []string{`[]`, `{"_data":{"user":[{"a":1}`, `{"_data":[]}`} {
+		var page objectPage
+		if err := page.decode(strings.NewReader(body), "user"); err == nil {
+			t.Errorf("Expected error decoding %s", body)
+		}
+	}
+}
+
+// objectServer serves count instances of object "user". honorOffset and
+// honorLimit tell if the query parameters are used, total if "_meta.total"
+// is returned.
+func objectServer(t *testing.T, count int, honorOffset, honorLimit, total bool) (*Controller, *int, func()) {
+	requests := 0
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests > 100 {
+			t.Error("Too many requests")
+			http.Error(w, "too many", http.StatusBadRequest)
+			return
+		}
+		offset, limit := 0, count
+		if value := r.URL.Query().Get("offset"); value != "" && honorOffset {
+			offset, _ = strconv.Atoi(value)
+		}
+		if value := r.URL.Query().Get("limit"); value != "" && honorLimit {
+			limit, _ = strconv.Atoi(value)
+		}
+		items := make([]map[string]int, 0)
+		for i := offset; i < count && i < offset+limit; i++ {
+			items = append(items, map[string]int{"id": i})
+		}
+		response := map[string]interface{}{"_data": map[string]interface{}{"user": items}}
+		if total {
+			response["_meta"] = map[string]int{"total": count}
+		}
+		json.NewEncoder(w).Encode(response)
+	}))
+	profile := &Profile{
+		Client:   &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}},
+		PageSize: 100,
+	}
+	return NewController(strings.TrimPrefix(server.URL, "https://"), profile, false), &requests, server.Close
+}
+
+func TestEachTermination(t *testing.T) {
+	tests := []struct {
+		name                           string
+		count                          int
+		honorOffset, honorLimit, total bool
+		requests                       int
+	}{
+		{name: "paged with total", count: 250, honorOffset: true, honorLimit: true, total: true, requests: 3},
+		{name: "paged, exact pages with total", count: 200, honorOffset: true, honorLimit: true, total: true, requests: 2},
+		{name: "paged without total", count: 250, honorOffset: true, honorLimit: true, requests: 3},
+		{name: "exact pages without total", count: 200, honorOffset: true, honorLimit: true, requests: 3},
+		{name: "ignored limit and offset", count: 600, requests: 1},
+		{name: "ignored limit", count: 600, honorOffset: true, requests: 1},
+		{name: "ignored offset", count: 250, honorLimit: true, requests: 3},
+		{name: "empty", count: 0, honorOffset: true, honorLimit: true, requests: 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c, requests, done := objectServer(t, test.count, test.honorOffset, test.honorLimit, test.total)
+			defer done()
+			seen := make(map[int]bool)
+			err := c.Each("/md", "object/user", nil, func(raw json.RawMessage) error {
+				var item struct {
+					ID int `json:"id"`
+				}
+				if err := json.Unmarshal(raw, &item); err != nil {
+					return err
+				}
+				if seen[item.ID] {
+					return fmt.Errorf("Item %d repeated", item.ID)
+				}
+				seen[item.ID] = true
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(seen) != test.count {
+				t.Errorf("Got %d items, expected %d", len(seen), test.count)
+			}
+			if *requests != test.requests {
+				t.Errorf("Made %d requests, expected %d", *requests, test.requests)
+			}
+		})
+	}
+}
+
+func TestGetPagedKeepsResponse(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"_data":{"user":{"name":"x"}},"_meta":{"filter":"none"},"_global_result":{"status":0}}`)
+	}))
+	defer server.Close()
+	profile := &Profile{
+		Client:   &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}},
+		PageSize: 100,
+		PagedGet: true,
+	}
+	c := NewController(strings.TrimPrefix(server.URL, "https://"), profile, false)
+	result, err := c.Get("/md", "object/user", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response := result.(map[string]interface{})
+	for _, key := range []string{"_meta", "_global_result"} {
+		if _, ok := response[key]; !ok {
+			t.Errorf("Attribute %s dropped", key)
+		}
+	}
+	user := response["_data"].(map[string]interface{})["user"]
+	if _, ok := user.(map[string]interface{}); !ok {
+		t.Errorf("Single instance returned as %T", user)
+	}
+}
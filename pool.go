@@ -1,52 +1,154 @@
 package main
 
 import (
-	"net/http"
+	"fmt"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/pkg/errors"
 )
 
 // Task is a command to run on a controller
 type Task struct {
-	Cmd  string
-	Path Lookup
-	Attr []string
+	Label string
+	Cmd   string
+	Path  Lookup
+	Attr  []string
+}
+
+// ParseTask builds a Task from a command of the form
+// <CLI command> | <jsonpath filter> > <comma-separated attributes>
+func ParseTask(command string) (Task, error) {
+	attrs := strings.SplitN(command, ">", 2)
+	paths := strings.SplitN(attrs[0], "|", 2)
+	curr := Task{Label: command, Cmd: strings.TrimSpace(paths[0]), Path: nil, Attr: nil}
+	if len(paths) > 1 {
+		compiled, err := NewLookup(paths[1])
+		if err != nil {
+			return curr, errors.Wrapf(err, "Error compiling expression '%s'", paths[1])
+		}
+		curr.Path = compiled
+	}
+	if len(attrs) > 1 {
+		curr.Attr = SplitNonEmpty(attrs[1], ",")
+	}
+	return curr, nil
 }
 
 // Result of one execution in the loop
 type Result struct {
-	Data []interface{}
-	Err  error
+	// Controller the result comes from
+	MD string
+	// Record of the controller from discovery
+	Switch Switch
+	// Labels of the tasks that produced the data
+	Tasks []string
+	// Time when the iteration started
+	Time time.Time
+	// AOS version of the controller, if already detected
+	Version string
+	Data    []interface{}
+	Err     error
+}
+
+// Sessions keeps the controllers dialed before the run, e.g. to query
+// cluster membership, so the pool does not have to log in again
+type Sessions struct {
+	mutex       sync.Mutex
+	controllers map[string]*Controller
+}
+
+// NewSessions returns an empty set of sessions
+func NewSessions() *Sessions {
+	return &Sessions{controllers: make(map[string]*Controller)}
+}
+
+// Put keeps the controller session open for later use.
+// If there is no set of sessions, the controller is closed.
+func (s *Sessions) Put(controller *Controller) {
+	if s == nil {
+		controller.Close()
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if previous, ok := s.controllers[controller.md]; ok {
+		previous.Close()
+	}
+	s.controllers[controller.md] = controller
+}
+
+// Take returns the session kept for the controller, nil if none.
+// The caller becomes responsible for closing it.
+func (s *Sessions) Take(md string) *Controller {
+	if s == nil {
+		return nil
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	controller := s.controllers[md]
+	delete(s.controllers, md)
+	return controller
+}
+
+// Close the sessions that were not taken
+func (s *Sessions) Close() {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for md, controller := range s.controllers {
+		controller.Close()
+		delete(s.controllers, md)
+	}
 }
 
 // Pool of worker gophers running commands in controllers
 type Pool struct {
-	client *http.Client
-	wg     sync.WaitGroup
-	delay  time.Duration
-	loop   time.Duration
-	sem    chan struct{}
-	cancel chan struct{}
+	profile *Profile
+	wg      sync.WaitGroup
+	delay   time.Duration
+	loop    time.Duration
+	sem     chan struct{}
+	cancel  chan struct{}
+	// Counters for the run summary
+	results  int64
+	failures int64
+	mutex    sync.Mutex
+	kinds    map[string]int
 }
 
 // NewPool returns a new Task Pool
-func NewPool(tasks int, delay, loop time.Duration, client *http.Client) *Pool {
+func NewPool(tasks int, delay, loop time.Duration, profile *Profile) *Pool {
 	p := &Pool{
-		client: client,
-		delay:  delay,
-		loop:   loop,
-		sem:    make(chan struct{}, tasks),
-		cancel: make(chan struct{}),
+		profile: profile,
+		delay:   delay,
+		loop:    loop,
+		sem:     make(chan struct{}, tasks),
+		cancel:  make(chan struct{}),
+		kinds:   make(map[string]int),
 	}
 	return p
 }
 
 // Push adds the tasks to the pool
-func (p *Pool) Push(md, username, pass string, commands []Task, script Script, useSSH bool) chan Result {
+func (p *Pool) Push(sw Switch, commands []Task, script Script, useSSH bool) chan Result {
 	// Leave notice a new thread is running
 	p.wg.Add(1)
-	controller := NewController(md, username, pass, p.client, useSSH)
+	controller := p.profile.Sessions.Take(sw.IP)
+	if controller == nil {
+		controller = NewController(sw.IP, p.profile, useSSH)
+	}
+	controller.useSSH, controller.info = useSSH, sw
 	stream := make(chan Result, 1)
+	labels := make([]string, 0, len(commands))
+	for _, cmd := range commands {
+		labels = append(labels, cmd.Label)
+	}
 	go func() {
 		defer p.wg.Done()
 		defer controller.Close()
@@ -55,6 +157,7 @@ func (p *Pool) Push(md, username, pass string, commands []Task, script Script, u
 			// Dial does session caching, will refresh credentials if needed
 			var data []interface{}
 			var done bool
+			started := time.Now()
 			err := controller.Dial()
 			if err == nil {
 				data, done, err = func() ([]interface{}, bool, error) {
@@ -66,7 +169,16 @@ func (p *Pool) Push(md, username, pass string, commands []Task, script Script, u
 					return p.run(controller, commands, script)
 				}()
 			}
-			stream <- Result{Data: data, Err: err}
+			p.count(err)
+			stream <- Result{
+				MD:      sw.IP,
+				Switch:  sw,
+				Tasks:   labels,
+				Time:    started,
+				Version: controller.version.String(),
+				Data:    data,
+				Err:     err,
+			}
 			if done || p.loop <= 0 {
 				return
 			}
@@ -90,6 +202,38 @@ func (p *Pool) Close() {
 	p.wg.Wait()
 }
 
+// count a result for the summary
+func (p *Pool) count(err error) {
+	atomic.AddInt64(&p.results, 1)
+	if err != nil {
+		atomic.AddInt64(&p.failures, 1)
+		p.mutex.Lock()
+		p.kinds[ErrorKind(err)]++
+		p.mutex.Unlock()
+	}
+}
+
+// Failures returns the number of failed results so far
+func (p *Pool) Failures() int {
+	return int(atomic.LoadInt64(&p.failures))
+}
+
+// Summary returns a line describing the outcome of the run
+func (p *Pool) Summary() string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	kinds := make([]string, 0, len(p.kinds))
+	for kind, count := range p.kinds {
+		kinds = append(kinds, fmt.Sprintf("%s: %d", kind, count))
+	}
+	sort.Strings(kinds)
+	failed := fmt.Sprintf("%d failed", atomic.LoadInt64(&p.failures))
+	if len(kinds) > 0 {
+		failed = fmt.Sprintf("%s (%s)", failed, strings.Join(kinds, ", "))
+	}
+	return fmt.Sprintf("%d results, %s, %d retries", atomic.LoadInt64(&p.results), failed, p.profile.Retry.Retries())
+}
+
 // run the required commands
 func (p *Pool) run(controller *Controller, commands []Task, script Script) ([]interface{}, bool, error) {
 	result := make([]interface{}, 0, len(commands))
@@ -102,7 +246,13 @@ func (p *Pool) run(controller *Controller, commands []Task, script Script) ([]in
 		} else if p.delay > 0 {
 			time.Sleep(p.delay)
 		}
-		curr, err := controller.Show(cmd.Cmd, cmd.Path)
+		var curr interface{}
+		var err error
+		if objectName(cmd.Cmd) != "" {
+			curr, err = controller.Object(cmd.Cmd, cmd.Path)
+		} else {
+			curr, err = controller.Show(cmd.Cmd, cmd.Path)
+		}
 		if err != nil {
 			return nil, false, err
 		}
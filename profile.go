@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Profile holds the credentials and connection settings shared
+// by all the controllers in a run
+type Profile struct {
+	Username string
+	Password string
+	Client   *http.Client
+	// Cache for API sessions, to reuse them across invocations. Optional.
+	Cache *SessionCache
+	// Policy for retrying failed operations. If nil, no retries.
+	Retry *RetryPolicy
+	// Verification of SSH host keys. If nil, keys are not checked.
+	HostKeys *HostKeys
+	// SSH authentication methods. If empty, the password is used.
+	SSHAuth []ssh.AuthMethod
+	// Dialer for connections to the controllers. If nil, dial directly.
+	Dialer Dialer
+	// Addresses of the controllers. If nil, default ports are used.
+	Addresses *Addresses
+	// Timeout for CLI prompts over SSH. If 0, 60 seconds.
+	Timeout time.Duration
+	// Recorder of SSH transcripts. Optional.
+	Transcripts *Transcripts
+	// Operations allowed to change the controllers. If nil, read-only.
+	Writes *WritePolicy
+	// Log of the operations performed. Optional.
+	Audit *AuditLog
+	// Instances per page when reading configuration objects. If 0, no paging.
+	PageSize int
+	// Also read configuration objects in pages with Get, not only with Each
+	PagedGet bool
+	// Recording of API and SSH responses, or replay of them. Optional.
+	Cassette *Cassette
+	// Sessions opened before the run, reused by the pool. Optional.
+	Sessions *Sessions
+}
+
+// Dial opens a connection to a controller, through the profile Dialer
+func (p *Profile) Dial(network, addr string) (net.Conn, error) {
+	if p.Dialer == nil {
+		return net.DialTimeout(network, addr, 30*time.Second)
+	}
+	return p.Dialer.Dial(network, addr)
+}
+
+// SSHConfig returns the configuration for SSH connections to the controllers
+func (p *Profile) SSHConfig() *ssh.ClientConfig {
+	auth := p.SSHAuth
+	if len(auth) <= 0 {
+		auth = []ssh.AuthMethod{ssh.Password(p.Password)}
+	}
+	return &ssh.ClientConfig{
+		User:            p.Username,
+		Auth:            auth,
+		HostKeyCallback: p.HostKeys.Callback(),
+	}
+}
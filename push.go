@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v3"
+)
+
+// PushItem is an entry in the objects file of the push subcommand
+type PushItem struct {
+	Object string                 `json:"object" yaml:"object"`
+	Data   map[string]interface{} `json:"data" yaml:"data"`
+}
+
+// actionObjects are objects that trigger an action instead of
+// storing configuration, they can't be read back to verify them
+var actionObjects = map[string]bool{
+	"flash_backup":    true,
+	"copy_flash_scp":  true,
+	"aaa_user_add":    true,
+	"aaa_user_delete": true,
+	"write_memory":    true,
+}
+
+// rawObject is a configuration object without a typed schema
+type rawObject struct {
+	name string
+	data map[string]interface{}
+}
+
+// Name implements Object
+func (o *rawObject) Name() string { return o.name }
+
+// Validate implements Object
+func (o *rawObject) Validate() error {
+	if o.name == "" {
+		return errors.New("Missing object name")
+	}
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler
+func (o *rawObject) MarshalJSON() ([]byte, error) {
+	if o.data == nil {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(o.data)
+}
+
+// LoadObjects reads the objects to push from a JSON or YAML file
+// (by extension). Objects with a known schema are checked strictly.
+func LoadObjects(filename string) ([]Object, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to read objects file '%s'", filename)
+	}
+	var items []PushItem
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &items)
+	default:
+		err = json.Unmarshal(data, &items)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to parse objects file '%s'", filename)
+	}
+	objects := make([]Object, 0, len(items))
+	for index, item := range items {
+		name := strings.TrimPrefix(item.Object, "object/")
+		var obj Object = &rawObject{name: name, data: item.Data}
+		if _, known := objectTypes[name]; known {
+			if obj, err = NewObject(name, item.Data); err != nil {
+				return nil, errors.Wrapf(err, "Object #%d in '%s'", index+1, filename)
+			}
+		}
+		if err := obj.Validate(); err != nil {
+			return nil, errors.Wrapf(err, "Object #%d in '%s'", index+1, filename)
+		}
+		objects = append(objects, obj)
+	}
+	if len(objects) <= 0 {
+		return nil, errors.Errorf("No objects found in '%s'", filename)
+	}
+	return objects, nil
+}
+
+// Push applies configuration objects to a set of config nodes
+type Push struct {
+	Objects []Object
+	// If true, only print the payloads
+	DryRun bool
+	// Stop the rollout after this many nodes failed
+	MaxFailures int
+	// Output for the dry run
+	Out io.Writer
+	// Nodes failed so far, across calls to Run
+	failed int
+}
+
+// Run pushes the objects to each node in turn, with write_memory and
+// verification. It can be called several times, e.g. once per wave.
+// Returns an error when the rollout must stop.
+func (p *Push) Run(mm *Controller, nodes []string) error {
+	for _, node := range nodes {
+		if p.DryRun {
+			if err := p.print(node); err != nil {
+				return err
+			}
+			continue
+		}
+		log.Println("Pushing", len(p.Objects), "objects to node", node)
+		if err := p.apply(mm, node); err != nil {
+			log.Println("Push to node", node, "failed:", err)
+			p.failed++
+			if p.failed >= p.MaxFailures {
+				return errors.Errorf("Stopping rollout after %d failed nodes", p.failed)
+			}
+			continue
+		}
+		log.Println("Push to node", node, "verified")
+	}
+	return nil
+}
+
+// Failed returns the number of nodes that failed so far
+func (p *Push) Failed() int {
+	return p.failed
+}
+
+// print the payloads for a node, in dry run mode
+func (p *Push) print(node string) error {
+	for _, obj := range p.Objects {
+		payload, err := json.MarshalIndent(obj, "", "  ")
+		if err != nil {
+			return errors.Wrapf(err, "Failed to marshal object '%s'", obj.Name())
+		}
+		fmt.Fprintf(p.Out, "POST object/%s (config_path %s)\n%s\n", obj.Name(), node, payload)
+	}
+	fmt.Fprintf(p.Out, "POST object/write_memory (config_path %s)\n", node)
+	return nil
+}
+
+// apply the objects to a node, save and verify them
+func (p *Push) apply(mm *Controller, node string) error {
+	if err := mm.Dial(); err != nil {
+		return err
+	}
+	for _, obj := range p.Objects {
+		if _, err := mm.PostObject(node, obj); err != nil {
+			return err
+		}
+	}
+	if err := mm.WriteMemory(node); err != nil {
+		return err
+	}
+	for _, obj := range p.Objects {
+		if err := verifyObject(mm, node, obj); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// verifyObject reads back the instances of the object at the node,
+// and checks one of them contains all the attributes pushed
+func verifyObject(mm *Controller, node string, obj Object) error {
+	if actionObjects[obj.Name()] {
+		return nil
+	}
+	marshaled, err := json.Marshal(obj)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to marshal object '%s'", obj.Name())
+	}
+	var expected interface{}
+	if err := json.Unmarshal(marshaled, &expected); err != nil {
+		return errors.Wrapf(err, "Failed to unmarshal object '%s'", obj.Name())
+	}
+	found := false
+	err = mm.Each(node, "object/"+obj.Name(), nil, func(raw json.RawMessage) error {
+		var actual interface{}
+		if err := json.Unmarshal(raw, &actual); err != nil {
+			return err
+		}
+		if contains(actual, expected) {
+			found = true
+			return io.EOF
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if !found {
+		return errors.Errorf("Object '%s' not found at node '%s' after push: '%s'", obj.Name(), node, Redact(string(marshaled)))
+	}
+	return nil
+}
+
+// contains returns true if every attribute in expected is also in actual.
+// Lists in actual may have more items than expected, in any order.
+// Secret attributes are skipped, AOS does not return them in plain text.
+func contains(actual, expected interface{}) bool {
+	switch expected := expected.(type) {
+	case map[string]interface{}:
+		actual, ok := actual.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		for key, value := range expected {
+			if secretFields.MatchString(key) {
+				continue
+			}
+			if !contains(actual[key], value) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		actual, ok := actual.([]interface{})
+		if !ok {
+			return false
+		}
+		for _, item := range expected {
+			found := false
+			for _, candidate := range actual {
+				if contains(candidate, item) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+		return true
+	}
+	return fmt.Sprintf("%v", actual) == fmt.Sprintf("%v", expected)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestContains(t *testing.T) {
+	tests := []struct {
+		name             string
+		actual, expected string
+		result           bool
+	}{
+		{"equal", `{"a":"x","b":1}`, `{"a":"x","b":1}`, true},
+		{"extra attributes", `{"a":"x","b":1}`, `{"a":"x"}`, true},
+		{"different value", `{"a":"y"}`, `{"a":"x"}`, false},
+		{"missing attribute", `{"b":1}`, `{"a":"x"}`, false},
+		{"list in any order", `{"l":[{"n":2},{"n":1}]}`, `{"l":[{"n":1}]}`, true},
+		{"missing list item", `{"l":[{"n":2}]}`, `{"l":[{"n":1}]}`, false},
+		{"masked password", `{"usrname":"admin","passwd":"********","role":"root"}`, `{"usrname":"admin","passwd":"secret","role":"root"}`, true},
+		{"password not returned", `{"usrname":"admin","role":"root"}`, `{"usrname":"admin","passwd":"secret","role":"root"}`, true},
+		{"nested secret", `{"l":[{"n":1}]}`, `{"l":[{"n":1,"psk":"secret"}]}`, true},
+		{"other role", `{"usrname":"admin","role":"read-only"}`, `{"usrname":"admin","passwd":"secret","role":"root"}`, false},
+	}
+	for _, test := range tests {
+		var actual, expected interface{}
+		if err := json.Unmarshal([]byte(test.actual), &actual); err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal([]byte(test.expected), &expected); err != nil {
+			t.Fatal(err)
+		}
+		if result := contains(actual, expected); result != test.result {
+			t.Errorf("%s: contains is %v, expected %v", test.name, result, test.result)
+		}
+	}
+}
+
+func TestVerifyMgmtUser(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"_data":{"mgmt_user":[{"usrname":"admin","passwd":"********","role":"root"}]}}`)
+	}))
+	defer server.Close()
+	profile := &Profile{
+		Client: &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}},
+	}
+	mm := NewController(strings.TrimPrefix(server.URL, "https://"), profile, false)
+	if err := verifyObject(mm, "/md", &MgmtUser{UsrName: "admin", Passwd: "secret", Role: "root"}); err != nil {
+		t.Error(err)
+	}
+	if err := verifyObject(mm, "/md", &MgmtUser{UsrName: "admin", Passwd: "secret", Role: "read-only"}); err == nil {
+		t.Error("Expected error verifying a different role")
+	}
+}
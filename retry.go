@@ -0,0 +1,162 @@
+package main
+
+import (
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// RetryPolicy decides which failed operations are retried, and how often
+type RetryPolicy struct {
+	// Maximum number of attempts, including the first one
+	Attempts int
+	// Wait time before the first retry. Doubles on every attempt.
+	Backoff time.Duration
+	// Upper limit for the wait time between attempts
+	MaxBackoff time.Duration
+	// Retry on timeouts
+	Timeouts bool
+	// Retry on connections reset or closed by the peer
+	Resets bool
+	// HTTP status codes worth retrying
+	Statuses map[int]bool
+	// Number of retries performed so far
+	retries int64
+}
+
+// DefaultRetryOn is the default list of retryable error classes
+const DefaultRetryOn = "timeout,reset,502,503,504"
+
+// NewRetryPolicy builds a RetryPolicy. The list of retryable error
+// classes can contain "timeout", "reset", and HTTP status codes.
+func NewRetryPolicy(attempts int, backoff time.Duration, retryOn []string) (*RetryPolicy, error) {
+	if attempts < 1 {
+		attempts = 1
+	}
+	p := &RetryPolicy{
+		Attempts:   attempts,
+		Backoff:    backoff,
+		MaxBackoff: 60 * time.Second,
+		Statuses:   make(map[int]bool),
+	}
+	for _, class := range retryOn {
+		switch strings.ToLower(class) {
+		case "timeout":
+			p.Timeouts = true
+		case "reset":
+			p.Resets = true
+		default:
+			code, err := strconv.Atoi(class)
+			if err != nil {
+				return nil, errors.Errorf("Unknown retryable error class '%s'", class)
+			}
+			if code == http.StatusUnauthorized {
+				// Retrying with the same credentials is pointless
+				return nil, errors.New("HTTP status 401 is never retried")
+			}
+			p.Statuses[code] = true
+		}
+	}
+	return p, nil
+}
+
+// Retries returns the number of retries performed by the policy
+func (p *RetryPolicy) Retries() int64 {
+	if p == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&p.retries)
+}
+
+// Retryable returns true if the error belongs to a retryable class
+func (p *RetryPolicy) Retryable(err error) bool {
+	if p == nil || err == nil {
+		return false
+	}
+	var auth *AuthError
+	if errors.As(err, &auth) {
+		// Retrying with the same credentials is pointless
+		return false
+	}
+	var status *APIStatusError
+	if errors.As(err, &status) {
+		return status.StatusCode != http.StatusUnauthorized && p.Statuses[status.StatusCode]
+	}
+	var final *finalError
+	if errors.As(err, &final) {
+		return false
+	}
+	if p.Resets {
+		// A plain io.EOF is not a reset, it may be an empty body
+		if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) ||
+			errors.Is(err, io.ErrUnexpectedEOF) {
+			return true
+		}
+	}
+	var netErr net.Error
+	if p.Timeouts && errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return false
+}
+
+// Do runs the operation, retrying it while it fails with retryable errors.
+// MD and op are used to log the retries.
+func (p *RetryPolicy) Do(MD, op string, f func() error) error {
+	if p == nil {
+		return unwrapFinal(f())
+	}
+	wait := p.Backoff
+	for attempt := 1; ; attempt++ {
+		err := f()
+		if err == nil || attempt >= p.Attempts || !p.Retryable(err) {
+			return unwrapFinal(err)
+		}
+		// Add jitter, wait between half and the whole backoff time
+		sleep := wait
+		if wait > 1 {
+			sleep = wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+		}
+		atomic.AddInt64(&p.retries, 1)
+		log.Printf("Retrying %s on '%s' in %s (attempt %d of %d): %v", op, MD, sleep, attempt+1, p.Attempts, err)
+		time.Sleep(sleep)
+		if wait *= 2; wait > p.MaxBackoff {
+			wait = p.MaxBackoff
+		}
+	}
+}
+
+// finalError marks an error that must not be retried, whatever its class.
+// Do returns the wrapped error.
+type finalError struct {
+	err error
+}
+
+func (e *finalError) Error() string { return e.err.Error() }
+
+// Unwrap returns the wrapped error
+func (e *finalError) Unwrap() error { return e.err }
+
+// Final marks the error as not retryable
+func Final(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &finalError{err: err}
+}
+
+func unwrapFinal(err error) error {
+	if final, ok := err.(*finalError); ok {
+		return final.err
+	}
+	return err
+}
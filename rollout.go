@@ -0,0 +1,149 @@
+package main
+
+import (
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Rollout splits a run in waves: a canary set first, and then
+// growing percentages of the targets, with a pause and a health
+// check between waves.
+type Rollout struct {
+	// Number of targets in the first wave. If 0, no canary wave.
+	Canary int
+	// Cumulative percentages of targets covered by each wave after the canary
+	Percents []int
+	// Pause after each wave, before the health check
+	Pause time.Duration
+	// Task to check the health of the controllers after each wave.
+	// It passes if the result is not empty. Optional.
+	Health *Task
+}
+
+// NewRollout builds a Rollout from the command line settings.
+// percents is a comma-separated list (e.g. "10,50,100").
+func NewRollout(canary int, percents string, pause time.Duration, health string) (*Rollout, error) {
+	r := &Rollout{Canary: canary, Pause: pause}
+	if canary < 0 {
+		return nil, errors.Errorf("Canary wave size must be positive, not %d", canary)
+	}
+	last := 0
+	for _, text := range SplitNonEmpty(percents, ",") {
+		percent, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(text), "%"))
+		if err != nil || percent <= last || percent > 100 {
+			return nil, errors.Errorf("Wave percentages must be increasing numbers up to 100, not '%s'", percents)
+		}
+		r.Percents, last = append(r.Percents, percent), percent
+	}
+	if health != "" {
+		task, err := ParseTask(health)
+		if err != nil {
+			return nil, err
+		}
+		r.Health = &task
+	}
+	return r, nil
+}
+
+// Enabled returns true if the targets are split in more than one wave
+func (r *Rollout) Enabled() bool {
+	return r != nil && (r.Canary > 0 || len(r.Percents) > 0)
+}
+
+// Waves returns the [start, end) bounds of each wave, for n targets
+func (r *Rollout) Waves(n int) [][2]int {
+	if !r.Enabled() {
+		return [][2]int{{0, n}}
+	}
+	waves, start := make([][2]int, 0, len(r.Percents)+2), 0
+	add := func(end int) {
+		if end > n {
+			end = n
+		}
+		if end > start {
+			waves = append(waves, [2]int{start, end})
+			start = end
+		}
+	}
+	add(r.Canary)
+	for _, percent := range r.Percents {
+		// Round up, so small percentages get at least one target
+		add((n*percent + 99) / 100)
+	}
+	add(n)
+	return waves
+}
+
+// Between runs after a wave, unless it is the last one: waits for
+// the pause, and then runs the health check on the given switches.
+func (r *Rollout) Between(wave, waves int, switches []Switch, profile *Profile, useSSH bool, tasks int) error {
+	if wave >= waves-1 {
+		return nil
+	}
+	if r.Pause > 0 {
+		log.Println("Wave", wave+1, "of", waves, "completed, pausing for", r.Pause)
+		time.Sleep(r.Pause)
+	}
+	if r.Health == nil || len(switches) <= 0 {
+		return nil
+	}
+	log.Println("Checking health of", len(switches), "controllers after wave", wave+1)
+	if tasks > len(switches) {
+		tasks = len(switches)
+	}
+	pool := NewPool(tasks, 0, 0, profile)
+	streams := make([]chan Result, 0, len(switches))
+	for _, sw := range switches {
+		streams = append(streams, pool.Push(sw, []Task{*r.Health}, nil, useSSH))
+	}
+	unhealthy := make([]string, 0)
+	for index, stream := range streams {
+		for result := range stream {
+			if result.Err != nil {
+				log.Println("Health check failed in", switches[index].Label(), ":", result.Err)
+				unhealthy = append(unhealthy, switches[index].Label())
+				continue
+			}
+			if len(result.Data) <= 0 || !healthy(result.Data[0]) {
+				log.Println("Health check did not pass in", switches[index].Label())
+				unhealthy = append(unhealthy, switches[index].Label())
+			}
+		}
+	}
+	pool.Close()
+	if len(unhealthy) > 0 {
+		return errors.Errorf("Health check failed after wave %d in %s", wave+1, strings.Join(unhealthy, ", "))
+	}
+	return nil
+}
+
+// healthy returns true if the value is not empty, false or zero
+func healthy(value interface{}) bool {
+	switch value := value.(type) {
+	case nil:
+		return false
+	case []interface{}:
+		return len(value) > 0
+	case []string:
+		// Output of SSH commands, lines may be blank
+		for _, line := range value {
+			if strings.TrimSpace(line) != "" {
+				return true
+			}
+		}
+		return false
+	case map[string]interface{}:
+		return len(value) > 0
+	case string:
+		return strings.TrimSpace(value) != ""
+	case bool:
+		return value
+	case float64:
+		return value != 0
+	}
+	return true
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRolloutWaves(t *testing.T) {
+	tests := []struct {
+		canary   int
+		percents string
+		n        int
+		waves    [][2]int
+	}{
+		{0, "", 5, [][2]int{{0, 5}}},
+		{1, "", 5, [][2]int{{0, 1}, {1, 5}}},
+		{1, "10,50,100", 20, [][2]int{{0, 1}, {1, 2}, {2, 10}, {10, 20}}},
+		{0, "50", 3, [][2]int{{0, 2}, {2, 3}}},
+		{0, "1,2", 10, [][2]int{{0, 1}, {1, 10}}},
+		{10, "50", 4, [][2]int{{0, 4}}},
+		{2, "10", 5, [][2]int{{0, 2}, {2, 5}}},
+		{1, "50", 0, [][2]int{}},
+	}
+	for _, test := range tests {
+		rollout, err := NewRollout(test.canary, test.percents, 0, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if waves := rollout.Waves(test.n); !reflect.DeepEqual(waves, test.waves) {
+			t.Errorf("Waves of %d with canary %d and '%s' are %v, expected %v", test.n, test.canary, test.percents, waves, test.waves)
+		}
+	}
+}
+
+func TestNewRolloutErrors(t *testing.T) {
+	for _, percents := range []string{"50,10", "0", "101", "x", "10,10"} {
+		if _, err := NewRollout(0, percents, 0, ""); err == nil {
+			t.Errorf("Expected error for percentages '%s'", percents)
+		}
+	}
+	if _, err := NewRollout(-1, "", 0, ""); err == nil {
+		t.Error("Expected error for negative canary")
+	}
+}
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"strings"
@@ -18,8 +20,9 @@ type Script interface {
 }
 
 type script struct {
-	vms    chan *otto.Otto
-	script *otto.Script
+	filename string
+	vms      chan *otto.Otto
+	script   *otto.Script
 }
 
 // NewScript returns a bundle of VM + script
@@ -39,7 +42,7 @@ func NewScript(filename string, src interface{}, copies int) (Script, error) {
 	for i := 1; i < copies; i++ {
 		vms <- vm.Copy()
 	}
-	return &script{vms: vms, script: s}, nil
+	return &script{filename: filename, vms: vms, script: s}, nil
 }
 
 // Run the script with a given controller and set of data
@@ -47,35 +50,74 @@ func (s *script) Run(controller *Controller, data []interface{}) (interface{}, b
 	// Get a free VM
 	vm, ok := <-s.vms
 	if !ok {
-		return nil, true, errors.New("No more VMs to run scripts on")
+		return nil, true, &ScriptError{MD: controller.IP(), Script: s.filename, Err: errors.New("No more VMs to run scripts on")}
 	}
 	defer func() { s.vms <- vm }()
 	// Some variables used for script execution
 	now := time.Now()
 	done := false
-	vm.Set("session", map[string]interface{}{
-		"post": s.jsPost(vm, controller),
-		"get":  s.jsGet(vm, controller),
-		"ip":   controller.IP(),
-		"date": now.Format("2006-01-02"),
-		"time": now.Format("15:04:05"),
+	session, err := s.jsSession(vm, controller, map[string]interface{}{
+		"post":   s.jsPost(vm, controller),
+		"get":    s.jsGet(vm, controller),
+		"cli":    s.jsCLI(vm, controller),
+		"object": s.jsObject(vm, controller),
+		"each":   s.jsEach(vm, controller),
+		"ip":     controller.IP(),
+		"switch": controller.Info().Fields(),
+		"date":   now.Format("2006-01-02"),
+		"time":   now.Format("15:04:05"),
 		"done": func(otto.FunctionCall) otto.Value {
 			done = true
 			return otto.UndefinedValue()
 		},
 	})
+	if err != nil {
+		return nil, false, &ScriptError{MD: controller.IP(), Script: s.filename, Err: err}
+	}
+	vm.Set("session", session)
 	vm.Set("data", data)
 	value, err := vm.Run(s.script)
 	if err != nil {
-		return nil, false, errors.Wrap(err, "Failed to run script")
+		return nil, false, &ScriptError{MD: controller.IP(), Script: s.filename, Err: err}
 	}
 	native, err := value.Export()
 	if err != nil {
-		return nil, done, errors.Wrapf(err, "Failed to export script result '%+v'", value)
+		return nil, done, &ScriptError{MD: controller.IP(), Script: s.filename, Err: errors.Wrapf(err, "Failed to export script result '%+v'", value)}
 	}
 	return native, done, nil
 }
 
+// jsSession builds the session object with the given attributes.
+// The AOS version is a getter, so it is only detected if the script
+// reads session.version.
+func (s *script) jsSession(vm *otto.Otto, controller *Controller, attribs map[string]interface{}) (*otto.Object, error) {
+	session, err := vm.Object(`({})`)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	for name, value := range attribs {
+		if err := session.Set(name, value); err != nil {
+			return nil, errors.Wrapf(err, "Failed to set session.%s", name)
+		}
+	}
+	descriptor, err := vm.Object(`({enumerable: true})`)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	descriptor.Set("get", func(otto.FunctionCall) otto.Value {
+		v, _ := otto.ToValue(controller.Version().String())
+		return v
+	})
+	object, err := vm.Get("Object")
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if _, err := object.Object().Call("defineProperty", session, "version", descriptor); err != nil {
+		return nil, errors.Wrap(err, "Failed to define session.version")
+	}
+	return session, nil
+}
+
 type requestFunc func(cfgPath, endpoint string, data interface{}) (interface{}, error)
 
 // Closure for an API request to the session
@@ -126,8 +168,95 @@ func (s *script) jsGet(vm *otto.Otto, controller *Controller) func(otto.Function
 	})
 }
 
+// Post a typed configuration object, return its "_global_result"
+func (s *script) jsObject(vm *otto.Otto, controller *Controller) func(otto.FunctionCall) otto.Value {
+	return apiCall(vm, func(cfgPath, name string, data interface{}) (interface{}, error) {
+		obj, err := NewObject(name, data)
+		if err != nil {
+			return nil, err
+		}
+		result, err := controller.PostObject(cfgPath, obj)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"status": result.Status, "status_str": result.StatusStr}, nil
+	})
+}
+
+// Iterate over the instances of a configuration object, page by page.
+// The callback is called for each instance, and can return false to stop.
+func (s *script) jsEach(vm *otto.Otto, controller *Controller) func(otto.FunctionCall) otto.Value {
+	return func(call otto.FunctionCall) otto.Value {
+		args := call.ArgumentList
+		if len(args) < 4 {
+			return ottoErr(errors.New("Too few arguments. Must provide (config_path, api_endpoint, data, callback)"))
+		}
+		if !args[0].IsString() || !args[1].IsString() {
+			return ottoErr(errors.New("First arguments must be config path and api endpoint (e.g. \"/md\", \"object/aaa_user\")"))
+		}
+		if !args[3].IsFunction() {
+			return ottoErr(errors.New("Fourth argument must be a callback function"))
+		}
+		cfgPath, endpoint, callback := args[0].String(), args[1].String(), args[3]
+		params := make(map[string]string)
+		if args[2].IsObject() {
+			data, err := args[2].Export()
+			if err != nil {
+				return ottoErr(err)
+			}
+			if data, ok := data.(map[string]interface{}); ok {
+				for k, v := range data {
+					params[k] = fmt.Sprintf("%v", v)
+				}
+			}
+		}
+		err := controller.Each(cfgPath, endpoint, params, func(raw json.RawMessage) error {
+			var item interface{}
+			if err := json.Unmarshal(raw, &item); err != nil {
+				return err
+			}
+			value, err := vm.ToValue(noWhitespace(item, controller.reg))
+			if err != nil {
+				return err
+			}
+			result, err := callback.Call(otto.UndefinedValue(), value)
+			if err != nil {
+				return err
+			}
+			if result.IsBoolean() {
+				if keep, _ := result.ToBoolean(); !keep {
+					return io.EOF
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return ottoErr(err)
+		}
+		return otto.NullValue()
+	}
+}
+
+// Run a CLI command via SSH, return the output
+func (s *script) jsCLI(vm *otto.Otto, controller *Controller) func(otto.FunctionCall) otto.Value {
+	return func(call otto.FunctionCall) otto.Value {
+		if len(call.ArgumentList) < 1 || !call.Argument(0).IsString() {
+			return ottoErr(errors.New("First argument must be a CLI command (e.g. \"show clock\")"))
+		}
+		output, err := controller.CLI(call.Argument(0).String())
+		if err != nil {
+			return ottoErr(err)
+		}
+		v, err := vm.ToValue(output)
+		if err != nil {
+			return ottoErr(err)
+		}
+		return v
+	}
+}
+
 func ottoErr(err error) otto.Value {
-	val, _ := otto.ToValue(err.Error())
+	val, _ := otto.ToValue(Redact(err.Error()))
 	return val
 }
 
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestScriptVersionOnDemand(t *testing.T) {
+	versions := 0
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("command") == "show version" {
+			versions++
+		}
+		fmt.Fprint(w, `{"_data":["ArubaOS (MODEL: Aruba7005), Version 8.6.0.4"]}`)
+	}))
+	defer server.Close()
+	profile := &Profile{
+		Client: &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}},
+	}
+	tests := []struct {
+		src      string
+		result   string
+		versions int
+	}{
+		{`session.ip`, "", 0},
+		{`session.version + " " + session.version`, "8.6.0.4 8.6.0.4", 1},
+	}
+	for _, test := range tests {
+		versions = 0
+		controller := NewController(strings.TrimPrefix(server.URL, "https://"), profile, false)
+		script, err := NewScript("test.js", test.src, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, _, err := script.Run(controller, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.result != "" && result != test.result {
+			t.Errorf("Script '%s' returned %v, expected %s", test.src, result, test.result)
+		}
+		if versions != test.versions {
+			t.Errorf("Script '%s' ran 'show version' %d times, expected %d", test.src, versions, test.versions)
+		}
+	}
+}
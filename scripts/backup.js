@@ -1,4 +1,4 @@
-// mmcollect -u xxxx -h xxxx -f "?(@.Model == 'ArubaMM-VA')" -s backup.js "show version"
+// mmcollect -u xxxx -h xxxx -role mm -allow-write -write-endpoints object/flash_backup,object/copy_flash_scp -s backup.js "show version"
 
 // Comprueba si el resultado del POST es un error
 function errMsg(result) {
@@ -1,4 +1,4 @@
-// mmcollect -u xxxx -h xxxx -s bug179415.js \
+// mmcollect -u xxxx -h xxxx -allow-write -write-endpoints object/aaa_user_delete -s bug179415.js \
 //     "show ip interface brief | $._data | inc 'vlan ';" \
 //     "show datapath session table | $._data | inc 'nh 0x'"
 
@@ -1,4 +1,4 @@
-// mmcollect -u xxxx -h xxxx -T 1800 -f "?(@.Model =~ /7010/) | ?(@.Version =~ /8.5.0/)" -s datapath_uplink.js \
+// mmcollect -u xxxx -h xxxx -T 1800 -f "?(@.Model =~ /7010/) | ?(@.Version =~ /8.5.0/)" -allow-write -write-endpoints object/tar_logs -s datapath_uplink.js \
 //     "show ip interface brief | $._data | inc 'vlan ';" \
 //     "show datapath session uplink | $._data | begin SIDX"
 
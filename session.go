@@ -8,6 +8,7 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
 	"regexp"
 	"strings"
@@ -19,8 +20,10 @@ import (
 
 // Controller spawns sessions to a Managed Device
 type Controller struct {
+	profile  *Profile
 	client   *http.Client
 	url      string
+	sshAddr  string
 	username string
 	password string
 	md       string
@@ -30,37 +33,97 @@ type Controller struct {
 	lastToken string
 	lastUsed  time.Time
 	expires   time.Time
+	// True if the token was loaded from the session cache
+	cachedToken bool
 	// Client for SSH access, and when was it last used
 	sshClient *ssh.Client
 	lastSSH   time.Time
+	// CLI running on the SSH client
+	shell *Expect
+	// Transcript of SSH sessions, opened on demand
+	transcript *Transcript
+	// AOS version, detected on first use
+	version Version
+	// Record of the controller from discovery, if known
+	info Switch
 }
 
 type loginResponse struct {
-	GlobalResult struct {
-		Status    string `json:"status"`
-		StatusStr string `json:"status_str"`
-		UIDARUBA  string `json:"UIDARUBA"`
-	} `json:"_global_result"`
+	GlobalResult GlobalResult `json:"_global_result"`
 }
 
 // NewController opens a session to a controller
-func NewController(md, username, pass string, client *http.Client, useSSH bool) *Controller {
+func NewController(md string, profile *Profile, useSSH bool) *Controller {
 	// Non-alphanumeric characters will get replaced by "_" in names
 	reg, _ := regexp.Compile("[^a-zA-Z0-9]+")
+	endpoints := profile.Addresses.Lookup(md)
 	return &Controller{
-		client:   client,
-		url:      fmt.Sprintf("https://%s:4343/v1", md),
+		profile:  profile,
+		client:   profile.Client,
+		url:      fmt.Sprintf("https://%s/v1", endpoints.API),
+		sshAddr:  endpoints.SSH,
 		reg:      reg,
-		username: username,
-		password: pass,
+		username: profile.Username,
+		password: profile.Password,
 		md:       md,
 		useSSH:   useSSH,
 	}
 }
 
+// Info returns the record of the controller from discovery.
+// If the controller was not discovered, only the IP is known.
+func (c *Controller) Info() Switch {
+	if c.info.IP == "" {
+		return Switch{IP: c.IP()}
+	}
+	return c.info
+}
+
+// audit records an operation on the controller in the audit log
+func (c *Controller) audit(entry AuditEntry) {
+	entry.Controller, entry.User = c.md, c.username
+	entry.Replayed = c.profile.Cassette.Replaying()
+	c.profile.Audit.Record(entry)
+}
+
+// ConfigPath returns the config_path of the node the controller
+// is attached to. If not known, MMs use DefaultNodePath, and MDs
+// fail: the MM configuration would be reported as theirs.
+func (c *Controller) ConfigPath() (string, error) {
+	info := c.Info()
+	if info.Node != "" {
+		return info.Node, nil
+	}
+	if role := info.Role(); role == RoleMM || role == RoleStandby {
+		return DefaultNodePath, nil
+	}
+	return "", errors.Errorf("Configuration node of '%s' is not known, it was not found in the configuration hierarchy", c.md)
+}
+
+// Object reads a configuration object ("object/<name>") at the
+// config_path of the controller node
+func (c *Controller) Object(endpoint string, path Lookup) (interface{}, error) {
+	if c.useSSH {
+		return nil, errors.Errorf("Configuration object '%s' can only be read via API, not SSH", endpoint)
+	}
+	cfgPath, err := c.ConfigPath()
+	if err != nil {
+		return nil, err
+	}
+	result, err := c.Get(cfgPath, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	if path != nil {
+		return path.Lookup(result)
+	}
+	return result, nil
+}
+
 // IP returns the address of the controller
 func (c *Controller) IP() string {
-	return c.md
+	host, _ := SplitTarget(c.md)
+	return host
 }
 
 // Login opens a new session to the controller
@@ -83,18 +146,24 @@ func (c *Controller) login() (token string, expires time.Time, err error) {
 		defer resp.Body.Close()
 	}
 	if err != nil {
-		return token, expires, errors.Wrapf(err, "Login request to MD '%s' failed", c.md)
+		return token, expires, &TransportError{MD: c.md, Op: "login", Err: err}
+	}
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return token, expires, &AuthError{MD: c.md, Op: "login", Err: errors.Errorf("Login incorrect (username '%s')", c.username)}
 	}
 	if resp.StatusCode != 200 {
-		return token, expires, errors.Errorf("MD '%s': Login incorrect (username '%s')", c.md, c.username)
+		return token, expires, &APIStatusError{MD: c.md, Op: "login", StatusCode: resp.StatusCode}
 	}
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return token, expires, errors.Errorf("MD '%s': Could not read login response", c.md)
+		return token, expires, &TransportError{MD: c.md, Op: "login", Err: errors.Wrap(err, "Could not read login response")}
 	}
 	lr := loginResponse{}
 	if err := json.Unmarshal(body, &lr); err != nil {
-		return token, expires, errors.Errorf("MD '%s': Expected login response, got '%s'", c.md, string(body))
+		return token, expires, &DecodeError{MD: c.md, Op: "login", Body: string(body), Err: err}
+	}
+	if lr.GlobalResult.Status != 0 {
+		return token, expires, &AuthError{MD: c.md, Op: "login", Err: errors.Errorf("Login incorrect (username '%s'): %s", c.username, lr.GlobalResult.StatusStr)}
 	}
 	token = lr.GlobalResult.UIDARUBA
 	for _, cookie := range c.client.Jar.Cookies(parsedURL) {
@@ -103,28 +172,31 @@ func (c *Controller) login() (token string, expires time.Time, err error) {
 			return token, expires, nil
 		}
 	}
-	return token, expires, errors.Errorf("MD '%s': No SESSION cookie received", c.md)
+	return token, expires, &AuthError{MD: c.md, Op: "login", Err: errors.New("No SESSION cookie received")}
 }
 
 // sshLogin opens a new session to the controller
 func (c *Controller) sshLogin() (*ssh.Client, error) {
-	config := &ssh.ClientConfig{
-		User: c.username,
-		Auth: []ssh.AuthMethod{
-			ssh.Password(c.password),
-		},
-		// I'm not managing ssh keys as of now
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-	}
-	client, err := ssh.Dial("tcp", fmt.Sprintf("%s:22", c.md), config)
+	config := c.profile.SSHConfig()
+	var client *ssh.Client
+	err := c.profile.Retry.Do(c.md, "SSH login", func() error {
+		var err error
+		client, err = sshDial(c.profile, c.sshAddr, config)
+		c.audit(AuditEntry{Action: AuditLogin, Method: "SSH", Error: errString(err)})
+		return err
+	})
 	if err != nil {
-		return nil, errors.Wrapf(err, "Failed to dial SSH to '%s'", c.md)
+		if strings.Contains(err.Error(), "unable to authenticate") {
+			return nil, &AuthError{MD: c.md, Op: "SSH login", Err: err}
+		}
+		return nil, &TransportError{MD: c.md, Op: "SSH login", Err: err}
 	}
 	return client, nil
 }
 
-func (c *Controller) logout() error {
+func (c *Controller) logout() (err error) {
 	defer func() {
+		c.audit(AuditEntry{Action: AuditLogout, Method: http.MethodGet, Endpoint: "api/logout", Error: errString(err)})
 		// Make sure we clean the struct no matter what
 		c.lastUsed = time.Time{}
 		c.lastToken = ""
@@ -155,7 +227,11 @@ func (c *Controller) sshLogout() error {
 	defer func() {
 		c.sshClient = nil
 		c.lastSSH = time.Time{}
+		c.shell = nil
 	}()
+	if c.shell != nil {
+		c.shell.Close()
+	}
 	if c.sshClient != nil {
 		return errors.WithStack(c.sshClient.Close())
 	}
@@ -166,15 +242,35 @@ func (c *Controller) sshLogout() error {
 func (c *Controller) Dial() error {
 	// ASlways dial the API
 	now := time.Now()
+	if c.lastToken == "" && c.profile.Cache != nil {
+		// Try to reuse a session from a previous run
+		if cached, ok := c.profile.Cache.Load(c.md, c.username); ok {
+			c.lastToken, c.cachedToken = cached.Token, true
+			AddSecret(cached.Token)
+			c.expires = cached.Expires
+			c.lastUsed = cached.LastUsed
+			if version, err := ParseVersion(cached.Version); err == nil {
+				c.version = version
+			}
+		}
+	}
 	if c.lastUsed.IsZero() || (!c.expires.IsZero() && c.expires.Before(now)) || (now.Sub(c.lastUsed).Minutes() > 5) {
 		if c.lastToken != "" {
 			c.logout()
 		}
-		token, expires, err := c.login()
+		var token string
+		var expires time.Time
+		err := c.profile.Retry.Do(c.md, "login", func() error {
+			var err error
+			token, expires, err = c.login()
+			c.audit(AuditEntry{Action: AuditLogin, Method: http.MethodPost, Endpoint: "api/login", Error: errString(err)})
+			return err
+		})
 		if err != nil {
 			return err
 		}
-		c.lastToken = token
+		c.lastToken, c.cachedToken = token, false
+		AddSecret(token)
 		c.expires = expires
 	}
 	c.lastUsed = now
@@ -185,7 +281,33 @@ func (c *Controller) Dial() error {
 	return nil
 }
 
+// detectVersion runs "show version" to find the AOS version of the controller
+func (c *Controller) detectVersion() {
+	data, err := c.Get("/mm", "showcommand", map[string]string{"command": "show version"})
+	if err == nil {
+		c.version, err = versionFromShow(data)
+	}
+	if err != nil {
+		// Not fatal, the version is reported as empty
+		log.Println("Could not detect AOS version of", c.md, ":", err)
+		c.version = Version{}
+	}
+}
+
+// Version returns the AOS version of the controller, empty if unknown.
+// It is detected on first use, the session must be dialed.
+func (c *Controller) Version() Version {
+	if c.version == nil {
+		c.detectVersion()
+	}
+	return c.version
+}
+
 func (c *Controller) sshDial(now time.Time) error {
+	if c.profile.Cassette.Replaying() {
+		// CLI output comes from the cassette
+		return nil
+	}
 	if (c.sshClient == nil) || c.lastSSH.IsZero() || (now.Sub(c.lastSSH).Minutes() > 5) {
 		if c.sshClient != nil {
 			c.sshLogout()
@@ -200,11 +322,42 @@ func (c *Controller) sshDial(now time.Time) error {
 	return nil
 }
 
+// invalidate the API session, so next Dial logs in again.
+// The token is forgotten, so Close does not save it to the cache.
+func (c *Controller) invalidate() {
+	c.lastUsed, c.lastToken = time.Time{}, ""
+	if c.profile.Cache != nil {
+		c.profile.Cache.Remove(c.md, c.username)
+	}
+}
+
+// recorder returns the transcript for SSH sessions, if enabled
+func (c *Controller) recorder() *Transcript {
+	if c.transcript == nil && c.profile.Transcripts != nil {
+		transcript, err := c.profile.Transcripts.Open(c.md)
+		if err != nil {
+			// Not worth failing the session for this
+			log.Println("Error opening transcript of", c.md, ":", err)
+			return nil
+		}
+		c.transcript = transcript
+	}
+	return c.transcript
+}
+
 // Close the controller
 func (c *Controller) Close() error {
 	var err error
 	if c.lastToken != "" {
-		if err1 := c.logout(); err1 != nil {
+		if c.profile.Cache != nil {
+			// Keep the session open, for the next run
+			err = c.profile.Cache.Save(c.md, c.username, CachedSession{
+				Token:    c.lastToken,
+				Expires:  c.expires,
+				LastUsed: c.lastUsed,
+				Version:  c.version.String(),
+			})
+		} else if err1 := c.logout(); err1 != nil {
 			err = err1
 		}
 	}
@@ -213,6 +366,10 @@ func (c *Controller) Close() error {
 			err = err2
 		}
 	}
+	if c.transcript != nil {
+		c.transcript.Close()
+		c.transcript = nil
+	}
 	if err != nil {
 		// A common pattern will be just defer session.Close()
 		// I don't want the error message to go unnoticed
@@ -225,33 +382,40 @@ func (c *Controller) Close() error {
 func (c *Controller) Get(cfgPath, endpoint string, data interface{}) (interface{}, error) {
 	var params map[string]string
 	switch data := data.(type) {
+	case nil:
 	case map[string]string:
 		params = data
 	case map[string]interface{}:
-		params := make(map[string]string)
+		params = make(map[string]string)
 		for k, v := range data {
 			switch v := v.(type) {
 			case string:
 				params[k] = v
 			default:
-				params[k] = fmt.Sprintf("%s", v)
+				params[k] = fmt.Sprintf("%v", v)
 			}
 		}
 	default:
 		return nil, errors.Errorf("Invalid params type: %T", data)
 	}
+	if objectName(endpoint) != "" && c.profile.PagedGet && c.profile.PageSize > 0 {
+		return c.getPaged(cfgPath, endpoint, params)
+	}
 	return c.apiRequest(http.MethodGet, cfgPath, endpoint, params, nil)
 }
 
-// Post request
+// Post request. Rejected unless allowed by the profile write policy.
 func (c *Controller) Post(cfgPath, endpoint string, data interface{}) (interface{}, error) {
-	var body io.Reader
+	if err := c.profile.Writes.CheckPost(c.md, endpoint); err != nil {
+		return nil, err
+	}
+	var body []byte
 	if data != nil {
 		marshaled, err := json.Marshal(data)
 		if err != nil {
 			return nil, errors.Wrapf(err, "Failed to marshal data to json: '%+v'", data)
 		}
-		body = bytes.NewReader(marshaled)
+		body = marshaled
 	}
 	return c.apiRequest(http.MethodPost, cfgPath, endpoint, nil, body)
 }
@@ -283,31 +447,125 @@ func (c *Controller) Show(cmd string, path Lookup) (interface{}, error) {
 		}
 		cmd = strings.Join([]string{cmd, filter}, " | ")
 	}
-	sshSession, err := c.sshClient.NewSession()
+	output, err := c.CLI(cmd)
 	if err != nil {
-		return nil, errors.Wrapf(err, "Failed to create SSH session to '%s'", c.md)
-	}
-	defer sshSession.Close()
-	// Once a Session is created, you can execute a single command on
-	// the remote side using the Run method.
-	var b, e bytes.Buffer
-	sshSession.Stdout = &b
-	sshSession.Stderr = &e
-	if err := sshSession.Run(cmd); err != nil {
-		return nil, errors.Wrapf(err, "Failed to Run SSH command on '%s'", c.md)
-	}
-	data := strings.Split(b.String(), "\n")
-	return append(data, strings.Split(e.String(), "\n")...), nil
+		return nil, err
+	}
+	return strings.Split(output, "\n"), nil
 }
 
-func (c *Controller) apiRequest(method, cfgPath, endpoint string, params map[string]string, body io.Reader) (interface{}, error) {
+// CLI runs a command in the controller CLI, via SSH.
+// Only "show" commands are allowed, unless the profile allows writes.
+func (c *Controller) CLI(cmd string) (string, error) {
+	if err := c.profile.Writes.CheckCLI(c.md, cmd); err != nil {
+		return "", err
+	}
+	if c.profile.Cassette.Replaying() {
+		output, err := c.profile.Cassette.ReplaySSH(c.sshAddr, cmd)
+		c.audit(AuditEntry{Action: AuditSSH, Command: cmd, Error: errString(err)})
+		return output, err
+	}
+	// sshDial retries the login. The command itself is not retried,
+	// it may have changed the configuration before failing.
+	if err := c.sshDial(time.Now()); err != nil {
+		return "", err
+	}
+	if c.shell == nil {
+		shell, err := NewExpect(c.sshClient, c.profile.Timeout, c.recorder())
+		if err != nil {
+			c.sshLogout()
+			return "", &TransportError{MD: c.md, Op: "SSH CLI", Err: err}
+		}
+		c.shell = shell
+	}
+	output, err := c.shell.Command(cmd)
+	c.audit(AuditEntry{Action: AuditSSH, Command: cmd, Error: errString(err)})
+	if err != nil {
+		// The connection may be unusable, start over next time
+		c.sshLogout()
+		return "", &TransportError{MD: c.md, Op: fmt.Sprintf("SSH command '%s'", cmd), Err: err}
+	}
+	if c.profile.Cassette.Recording() {
+		if err := c.profile.Cassette.RecordSSH(c.sshAddr, cmd, output); err != nil {
+			log.Println("Error recording", c.md, "in cassette:", err)
+		}
+	}
+	return output, nil
+}
+
+func (c *Controller) apiRequest(method, cfgPath, endpoint string, params map[string]string, body []byte) (interface{}, error) {
+	bodyBytes, err := c.apiRaw(method, cfgPath, endpoint, params, body)
+	if err != nil {
+		return nil, err
+	}
+	var data interface{}
+	if err := json.Unmarshal(bodyBytes, &data); err != nil {
+		op := fmt.Sprintf("%s %s (config_path %s)", method, endpoint, cfgPath)
+		return nil, &DecodeError{MD: c.md, Op: op, Body: string(bodyBytes), Err: err}
+	}
+	result := noWhitespace(data, c.reg)
+	return result, nil
+}
+
+// apiRaw performs an API request, returns the raw response body
+func (c *Controller) apiRaw(method, cfgPath, endpoint string, params map[string]string, body []byte) ([]byte, error) {
+	var result []byte
+	err := c.apiStream(method, cfgPath, endpoint, params, body, func(r io.Reader) error {
+		var err error
+		result, err = ioutil.ReadAll(r)
+		return err
+	})
+	return result, err
+}
+
+// apiStream performs an API request, and hands the response body to read.
+// read may be called more than once, if the request is retried. Requests
+// other than GET are only retried if they were not sent to the controller.
+func (c *Controller) apiStream(method, cfgPath, endpoint string, params map[string]string, body []byte, read func(io.Reader) error) error {
+	op, cached := fmt.Sprintf("%s %s", method, endpoint), c.cachedToken
+	attempt := func() error {
+		return c.apiAttempt(method, cfgPath, endpoint, params, body, read)
+	}
+	err := c.profile.Retry.Do(c.md, op, attempt)
+	var auth *AuthError
+	if cached && errors.As(err, &auth) {
+		// The cached session was purged (e.g. the controller rebooted),
+		// log in again and repeat the request once
+		log.Println("Cached session rejected by", c.md, ", logging in again")
+		if err := c.Dial(); err != nil {
+			return err
+		}
+		err = c.profile.Retry.Do(c.md, op, attempt)
+	}
+	return err
+}
+
+// apiAttempt performs a single API request
+func (c *Controller) apiAttempt(method, cfgPath, endpoint string, params map[string]string, body []byte, read func(io.Reader) error) (err error) {
 	if strings.HasPrefix(endpoint, "/") {
 		endpoint = endpoint[1:]
 	}
+	status, sent := 0, false
+	defer func() {
+		if err != nil && sent && method != http.MethodGet {
+			// The controller may have applied the write already
+			err = Final(err)
+		}
+		entry := AuditEntry{Action: AuditAPI, Method: method, Endpoint: endpoint, ConfigPath: cfgPath, Status: status, Error: errString(err)}
+		if endpoint == "showcommand" {
+			entry.Action, entry.Command = AuditShow, params["command"]
+		} else if len(params) > 0 {
+			entry.Payload = params
+		}
+		if body != nil {
+			entry.Payload = auditPayload(body)
+		}
+		c.audit(entry)
+	}()
 	textURL := fmt.Sprintf("%s/configuration/%s", c.url, endpoint)
 	apiURL, err := url.Parse(textURL)
 	if err != nil {
-		return "", errors.Wrapf(err, "Failed to parse url '%s'", textURL)
+		return errors.Wrapf(err, "Failed to parse url '%s'", textURL)
 	}
 	query := apiURL.Query()
 	query.Set("config_path", cfgPath)
@@ -319,40 +577,67 @@ func (c *Controller) apiRequest(method, cfgPath, endpoint string, params map[str
 		}
 	}
 	apiURL.RawQuery = query.Encode()
-	req, err := http.NewRequest(method, apiURL.String(), body)
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, apiURL.String(), reader)
 	if err != nil {
-		return nil, errors.Wrapf(err, "Failed to build request for md '%s'", c.md)
+		return errors.Wrapf(err, "Failed to build request for md '%s'", c.md)
 	}
 	if body != nil {
 		req.Header.Add("Content-Type", "application/json")
 	}
 	req.Header.Add("Cookie", fmt.Sprintf("SESSION=%s", c.lastToken))
 	req.Header.Add("Accept", "application/json")
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), &httptrace.ClientTrace{
+		WroteHeaders: func() { sent = true },
+	}))
 	resp, err := c.client.Do(req)
 	if resp != nil && resp.Body != nil {
 		defer resp.Body.Close()
 	}
+	op := fmt.Sprintf("%s %s (config_path %s)", method, endpoint, cfgPath)
 	if err != nil {
-		return nil, errors.Wrapf(err, "Failed to run request from md '%s'", c.md)
+		return &TransportError{MD: c.md, Op: op, Err: err}
+	}
+	status = resp.StatusCode
+	if resp.StatusCode == http.StatusUnauthorized {
+		// The session is no longer valid
+		c.invalidate()
+		return &AuthError{MD: c.md, Op: op, Err: errors.New("Session rejected")}
 	}
 	if resp.StatusCode != 200 {
-		return nil, errors.Errorf("%s '%s' returned error code %d", method, apiURL.String(), resp.StatusCode)
+		return &APIStatusError{MD: c.md, Op: op, StatusCode: resp.StatusCode}
 	}
-	bodyBytes, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, errors.Wrapf(err, "Failed to read response body from md '%s'", c.md)
+	stream := &trackedReader{reader: resp.Body}
+	if err := read(stream); err != nil {
+		if stream.err != nil || errors.Is(err, io.ErrUnexpectedEOF) {
+			return &TransportError{MD: c.md, Op: op, Err: errors.Wrap(err, "Failed to read response body")}
+		}
+		return err
 	}
-	var data interface{}
-	if err := json.Unmarshal(bodyBytes, &data); err != nil {
-		return nil, errors.Wrapf(err, "Failed to decode data '%s'", string(bodyBytes))
+	return nil
+}
+
+// trackedReader remembers read errors, to tell transport failures
+// from decoding failures when the body is streamed
+type trackedReader struct {
+	reader io.Reader
+	err    error
+}
+
+func (t *trackedReader) Read(p []byte) (int, error) {
+	n, err := t.reader.Read(p)
+	if err != nil && err != io.EOF {
+		t.err = err
 	}
-	result := noWhitespace(data, c.reg)
-	return result, nil
+	return n, err
 }
 
-// Switches lists the IP addresses of the switches that comply with the given filter
+// Switches lists the switches that comply with the given filter
 // e.g. Switches("?(@.State=='up')") return switches up
-func (c *Controller) Switches(filter Lookup) ([]string, error) {
+func (c *Controller) Switches(filter Lookup) ([]Switch, error) {
 	if c.useSSH {
 		return nil, errors.New("Switches can only be listed via API, not SSH")
 	}
@@ -371,7 +656,19 @@ func (c *Controller) Switches(filter Lookup) ([]string, error) {
 	if err != nil {
 		return nil, err
 	}
-	return Select(data, []string{"IP_Address"})
+	rows, ok := data.([]interface{})
+	if !ok {
+		rows = []interface{}{data}
+	}
+	switches := make([]Switch, 0, len(rows))
+	for _, row := range rows {
+		sw, err := NewSwitch(row)
+		if err != nil {
+			return nil, err
+		}
+		switches = append(switches, sw)
+	}
+	return switches, nil
 }
 
 // noWhitespace removes non-alphanumeric characters from keys
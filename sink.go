@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+)
+
+// Sink receives the results of a run
+type Sink interface {
+	// Start is called once, before any result is written
+	Start(controllers []Switch, tasks []string) error
+	// Write is called for every Result delivered by a controller
+	Write(result Result) error
+	// Done is called when a controller will not deliver more results
+	Done(MD string) error
+	// End is called once, after all controllers are done
+	End() error
+}
+
+// textSink turns results into lines of text, and dumps them to
+// the writers built by a WriterFactory
+type textSink struct {
+	factory WriterFactory
+	header  bool
+}
+
+// NewSink returns a text sink writing to stdout if prefix is empty,
+// or to a file per controller otherwise. The prefix can include
+// placeholders for the switch fields, see Switch.Expand.
+func NewSink(prefix string, header bool) Sink {
+	return &textSink{factory: NewFactory(prefix), header: header}
+}
+
+// Start implements Sink
+func (s *textSink) Start(controllers []Switch, tasks []string) error {
+	return nil
+}
+
+// Write implements Sink
+func (s *textSink) Write(result Result) error {
+	sw, data, err := result.Switch, result.Data, result.Err
+	if sw.IP == "" {
+		sw.IP = result.MD
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, "Error in", sw.Label(), "stream:", err)
+		return nil
+	}
+	lines := []string{}
+	for idx, curr := range data {
+		if s.header {
+			label := "---"
+			if len(result.Tasks) > idx {
+				label = result.Tasks[idx]
+			}
+			lines = append(lines, fmt.Sprintf(">>> %s", label))
+		}
+		partial, err := Select(curr, nil)
+		if err != nil {
+			fmt.Fprintln(stderr, "Error in", sw.Label(), "select:", err)
+			continue
+		}
+		lines = append(lines, partial...)
+	}
+	// Open the writer each time, to avoid too many handles kept open
+	w, err := s.factory(sw)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Done implements Sink
+func (s *textSink) Done(MD string) error {
+	return nil
+}
+
+// End implements Sink
+func (s *textSink) End() error {
+	return nil
+}
+
+// Sinks fans out results to a list of Sink objects
+type Sinks []Sink
+
+// Start implements Sink
+func (s Sinks) Start(controllers []Switch, tasks []string) error {
+	for _, sink := range s {
+		if err := sink.Start(controllers, tasks); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Write implements Sink
+func (s Sinks) Write(result Result) error {
+	for _, sink := range s {
+		if err := sink.Write(result); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Done implements Sink
+func (s Sinks) Done(MD string) error {
+	for _, sink := range s {
+		if err := sink.Done(MD); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// End implements Sink
+func (s Sinks) End() error {
+	for _, sink := range s {
+		if err := sink.End(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
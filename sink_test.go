@@ -0,0 +1,57 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSinksFanOut(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	prefixes := []string{filepath.Join(dir, "a-"), filepath.Join(dir, "b-")}
+	sinks := Sinks{NewSink(prefixes[0], false), NewSink(prefixes[1], true)}
+	if err := sinks.Start(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	result := Result{MD: "10.0.0.1", Tasks: []string{"show version"}, Data: []interface{}{"line 1\nline 2"}}
+	if err := sinks.Write(result); err != nil {
+		t.Fatal(err)
+	}
+	if err := sinks.Done(result.MD); err != nil {
+		t.Fatal(err)
+	}
+	if err := sinks.End(); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"line 1\nline 2\n", ">>> show version\nline 1\nline 2\n"}
+	for i, prefix := range prefixes {
+		data, err := ioutil.ReadFile(prefix + "10.0.0.1.log")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != expected[i] {
+			t.Errorf("Sink %d wrote %q, expected %q", i, data, expected[i])
+		}
+	}
+}
+
+func TestSinksStopOnError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	missing, prefix := filepath.Join(dir, "missing", "a-"), filepath.Join(dir, "b-")
+	sinks := Sinks{NewSink(missing, false), NewSink(prefix, false)}
+	if err := sinks.Write(Result{MD: "10.0.0.1", Data: []interface{}{"line"}}); err == nil {
+		t.Fatal("Expected error writing to a missing folder")
+	}
+	if _, err := os.Stat(prefix + "10.0.0.1.log"); !os.IsNotExist(err) {
+		t.Error("Sink written after the previous one failed")
+	}
+}
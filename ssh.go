@@ -1,57 +1,25 @@
 package main
 
 import (
-	"bytes"
-	"fmt"
-	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 	"golang.org/x/crypto/ssh"
 )
 
-// sshInteract runs an interactive command via SSH
-func sshInteract(addr, user, pass, cmd, stdin string) (string, error) {
-	client, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
-		User: user,
-		Auth: []ssh.AuthMethod{
-			ssh.Password(pass),
-		},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-		BannerCallback:  ssh.BannerDisplayStderr(),
-	})
+// sshInteract runs an interactive command via SSH, answering the
+// prompts described by steps. Returns the output of the command.
+func sshInteract(dialer Dialer, addr string, config *ssh.ClientConfig, timeout time.Duration, transcript *Transcript, cmd string, steps []Step) (string, error) {
+	config.BannerCallback = ssh.BannerDisplayStderr()
+	client, err := sshDial(dialer, addr, config)
 	if err != nil {
 		return "", errors.Wrapf(err, "Failed to SSH to '%s'", addr)
 	}
 	defer client.Close()
-	out, err := runCommand(client, cmd, stdin)
+	shell, err := NewExpect(client, timeout, transcript)
 	if err != nil {
-		return "", err
+		return "", errors.Wrapf(err, "Failed to open CLI on '%s'", addr)
 	}
-	return out, nil
-}
-
-func runCommand(client *ssh.Client, command, stdin string) (string, error) {
-	sess, err := client.NewSession()
-	if err != nil {
-		return "", err
-	}
-	defer sess.Close()
-	var b bytes.Buffer
-	var e bytes.Buffer
-	if stdin != "" {
-		command = fmt.Sprintf("%s\n%s\n", command, stdin)
-	} else {
-		command = fmt.Sprintf("%s\n", command)
-	}
-	sess.Stdin = strings.NewReader(command)
-	sess.Stdout = &b
-	sess.Stderr = &e
-	if err = sess.Shell(); err != nil /* .Start(command); err != nil */ {
-		return "", errors.Wrapf(err, "Failed to run command '%s' through remote shell", command)
-	}
-	if err = sess.Wait(); err != nil {
-		return "", errors.Wrapf(err, "Failed to finish command '%s'", command)
-	}
-	combined := strings.Join([]string{b.String(), e.String()}, "")
-	return combined, nil
+	defer shell.Close()
+	return shell.Script(cmd, steps)
 }
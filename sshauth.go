@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// SSH authentication methods
+const (
+	// SSHAuthPassword authenticates with the user password
+	SSHAuthPassword = "password"
+	// SSHAuthKey authenticates with a private key file
+	SSHAuthKey = "key"
+	// SSHAuthAgent authenticates with the keys in ssh-agent (SSH_AUTH_SOCK)
+	SSHAuthAgent = "agent"
+	// SSHAuthKeyboard answers keyboard-interactive challenges with the user password
+	SSHAuthKeyboard = "keyboard-interactive"
+)
+
+// NewSSHAuth builds the list of SSH authentication methods, in the given order
+func NewSSHAuth(methods []string, password, keyFile string) ([]ssh.AuthMethod, error) {
+	result := make([]ssh.AuthMethod, 0, len(methods))
+	// The ssh client does not try the same method twice, so key file
+	// and agent signers must be bundled in a single "publickey" method.
+	var signers []ssh.Signer
+	publicKey := -1
+	for _, method := range methods {
+		switch strings.ToLower(method) {
+		case SSHAuthPassword:
+			result = append(result, ssh.Password(password))
+		case SSHAuthKeyboard:
+			result = append(result, ssh.KeyboardInteractive(keyboardChallenge(password)))
+		case SSHAuthKey:
+			signer, err := loadKey(keyFile)
+			if err != nil {
+				return nil, err
+			}
+			signers = append(signers, signer)
+		case SSHAuthAgent:
+			agentSigners, err := agentKeys()
+			if err != nil {
+				return nil, err
+			}
+			signers = append(signers, agentSigners...)
+		default:
+			return nil, errors.Errorf("Unknown SSH auth method '%s', must be one of '%s', '%s', '%s' or '%s'",
+				method, SSHAuthPassword, SSHAuthKey, SSHAuthAgent, SSHAuthKeyboard)
+		}
+		if publicKey < 0 && len(signers) > 0 {
+			publicKey = len(result)
+			result = append(result, nil)
+		}
+	}
+	if publicKey >= 0 {
+		result[publicKey] = ssh.PublicKeys(signers...)
+	}
+	if len(result) <= 0 {
+		return nil, errors.New("No SSH auth method selected")
+	}
+	return result, nil
+}
+
+// keyboardChallenge answers every non-echoed question with the password
+func keyboardChallenge(password string) ssh.KeyboardInteractiveChallenge {
+	return func(user, instruction string, questions []string, echos []bool) ([]string, error) {
+		answers := make([]string, len(questions))
+		for i := range questions {
+			if !echos[i] {
+				answers[i] = password
+			}
+		}
+		return answers, nil
+	}
+}
+
+// loadKey reads a private key file, asking for the passphrase if needed.
+// The passphrase can also be provided in env var MMCOLLECT_SSH_PASSPHRASE.
+func loadKey(keyFile string) (ssh.Signer, error) {
+	if keyFile == "" {
+		return nil, errors.New("Missing private key file for SSH key auth")
+	}
+	pemBytes, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to read private key file '%s'", keyFile)
+	}
+	signer, err := ssh.ParsePrivateKey(pemBytes)
+	if _, ok := err.(*ssh.PassphraseMissingError); !ok {
+		return signer, errors.Wrapf(err, "Failed to parse private key file '%s'", keyFile)
+	}
+	passphrase := os.Getenv("MMCOLLECT_SSH_PASSPHRASE")
+	if passphrase == "" {
+		fmt.Fprintf(os.Stderr, "Passphrase for '%s': ", keyFile)
+		passBytes, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+		if err != nil {
+			return nil, err
+		}
+		passphrase = string(passBytes)
+		fmt.Fprintln(os.Stderr, "")
+	}
+	AddSecret(passphrase)
+	signer, err = ssh.ParsePrivateKeyWithPassphrase(pemBytes, []byte(passphrase))
+	return signer, errors.Wrapf(err, "Failed to decrypt private key file '%s'", keyFile)
+}
+
+// agentKeys gets the signers from the ssh-agent at SSH_AUTH_SOCK.
+// The connection to the agent is kept open while the program runs.
+func agentKeys() ([]ssh.Signer, error) {
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if socket == "" {
+		return nil, errors.New("SSH_AUTH_SOCK is not set, ssh-agent not available")
+	}
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to connect to ssh-agent at '%s'", socket)
+	}
+	signers, err := agent.NewClient(conn).Signers()
+	if err != nil {
+		conn.Close()
+		return nil, errors.Wrap(err, "Failed to get keys from ssh-agent")
+	}
+	if len(signers) <= 0 {
+		conn.Close()
+		return nil, errors.New("No keys in ssh-agent")
+	}
+	return signers, nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Switch is a controller record, as reported by "show switches"
+type Switch struct {
+	IP          string
+	Name        string
+	Model       string
+	Version     string
+	Location    string
+	Type        string
+	ConfigState string
+	ConfigID    string
+	Status      string
+	// Path of the configuration node the switch is attached to
+	// (e.g. "/md/EMEA/Madrid"), and MAC address, from the node hierarchy
+	Node string
+	MAC  string
+	// Cluster name and role of the switch in the cluster (leader or member),
+	// if cluster membership was queried
+	Cluster     string
+	ClusterRole string
+	// Addresses of the cluster members, sorted, to tell apart
+	// clusters with the same profile name
+	ClusterMembers []string
+}
+
+// NewSwitch builds a Switch from a row of "show switches", once keys
+// have been normalized (e.g. "IP Address" becomes "IP_Address")
+func NewSwitch(row interface{}) (Switch, error) {
+	fields, ok := row.(map[string]interface{})
+	if !ok {
+		return Switch{}, errors.Errorf("Unexpected switch record '%v'", row)
+	}
+	get := func(key string) string {
+		if value, ok := fields[key]; ok && value != nil {
+			return strings.TrimSpace(fmt.Sprintf("%v", value))
+		}
+		return ""
+	}
+	sw := Switch{
+		IP:          get("IP_Address"),
+		Name:        get("Name"),
+		Model:       get("Model"),
+		Version:     get("Version"),
+		Location:    get("Location"),
+		Type:        get("Type"),
+		ConfigState: get("Configuration_State"),
+		ConfigID:    get("Config_ID"),
+		Status:      get("Status"),
+	}
+	if sw.IP == "" {
+		return sw, errors.Errorf("Missing IP_Address in switch record '%v'", row)
+	}
+	return sw, nil
+}
+
+// Label to identify the switch in output, e.g. "madrid-md1 (10.0.0.1)"
+func (s Switch) Label() string {
+	if s.Name == "" {
+		return s.IP
+	}
+	return fmt.Sprintf("%s (%s)", s.Name, s.IP)
+}
+
+// Fields returns the switch record as a map, with the same keys
+// used in output file name placeholders and scripts
+func (s Switch) Fields() map[string]interface{} {
+	return map[string]interface{}{
+		"ip":           s.IP,
+		"name":         s.Name,
+		"model":        s.Model,
+		"version":      s.Version,
+		"location":     s.Location,
+		"type":         s.Type,
+		"config_state": s.ConfigState,
+		"config_id":    s.ConfigID,
+		"status":       s.Status,
+		"node":         s.Node,
+		"mac":          s.MAC,
+		"cluster":      s.Cluster,
+		"cluster_role": s.ClusterRole,
+	}
+}
+
+// Expand replaces placeholders like "{name}" or "{location}" in text
+// with the fields of the switch. Values are made safe for file names.
+func (s Switch) Expand(text string) string {
+	for key, value := range s.Fields() {
+		placeholder := "{" + key + "}"
+		if !strings.Contains(text, placeholder) {
+			continue
+		}
+		safe := unsafeFileChars.ReplaceAllString(fmt.Sprintf("%v", value), "_")
+		if safe == "" {
+			safe = "_"
+		}
+		text = strings.Replace(text, placeholder, safe, -1)
+	}
+	return text
+}
+
+// Roles of the switches, for -role
+const (
+	RoleMM      = "mm"
+	RoleStandby = "standby"
+	RoleMD      = "md"
+	RoleAll     = "all"
+)
+
+// Role classifies the switch by the Type column in "show switches":
+// "master" (or "conductor") is the MM, "standby" the standby MM,
+// anything else (e.g. "MD") is a managed device.
+func (s Switch) Role() string {
+	switch strings.ToLower(s.Type) {
+	case "master", "conductor", "mm":
+		return RoleMM
+	case "standby":
+		return RoleStandby
+	}
+	return RoleMD
+}
+
+// WithRoles returns the switches with any of the given roles
+func WithRoles(switches []Switch, roles []string) ([]Switch, error) {
+	wanted := make(map[string]bool, len(roles))
+	for _, role := range roles {
+		role = strings.ToLower(strings.TrimSpace(role))
+		switch role {
+		case RoleAll:
+			return switches, nil
+		case RoleMM, RoleStandby, RoleMD:
+			wanted[role] = true
+		default:
+			return nil, errors.Errorf("Unknown role '%s', must be one of %s, %s, %s or %s", role, RoleMD, RoleMM, RoleStandby, RoleAll)
+		}
+	}
+	selected := make([]Switch, 0, len(switches))
+	for _, sw := range switches {
+		if wanted[sw.Role()] {
+			selected = append(selected, sw)
+		}
+	}
+	return selected, nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"io/ioutil"
+	"net"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// NewTLSConfig builds the TLS configuration for the REST API.
+// If caFile is not empty, server certificates are verified against it
+// instead of the system roots. certFile and keyFile are the optional
+// client certificate and key.
+func NewTLSConfig(verify bool, caFile, certFile, keyFile string) (*tls.Config, error) {
+	config := &tls.Config{InsecureSkipVerify: !verify}
+	if caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to read CA file '%s'", caFile)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.Errorf("No PEM certificates found in CA file '%s'", caFile)
+		}
+		config.RootCAs = pool
+	}
+	if certFile != "" || keyFile != "" {
+		if certFile == "" || keyFile == "" {
+			return nil, errors.New("Client certificate and key must be provided together")
+		}
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to load client certificate '%s'", certFile)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// dialTLS returns a DialTLSContext function for http.Transport, that
+// applies the server name and pinned certificate of each controller.
+func dialTLS(dialer Dialer, base *tls.Config, addresses *Addresses) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		endpoints := addresses.ByAPI(addr)
+		config := base.Clone()
+		if config.ServerName = endpoints.ServerName; config.ServerName == "" {
+			config.ServerName, _ = SplitTarget(addr)
+		}
+		if endpoints.Pin != "" {
+			// The pin replaces the verification of the certificate chain
+			pin := endpoints.Pin
+			config.InsecureSkipVerify = true
+			config.VerifyConnection = func(state tls.ConnectionState) error {
+				if len(state.PeerCertificates) <= 0 {
+					return errors.Errorf("No certificate received from '%s'", addr)
+				}
+				sum := sha256.Sum256(state.PeerCertificates[0].Raw)
+				if got := hex.EncodeToString(sum[:]); got != pin {
+					return errors.Errorf("Certificate of '%s' does not match pin: got %s, expected %s", addr, got, pin)
+				}
+				return nil
+			}
+		}
+		conn, err := dialer.Dial(network, addr)
+		if err != nil {
+			return nil, err
+		}
+		tlsConn := tls.Client(conn, config)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return tlsConn, nil
+	}
+}
+
+// normalizePin turns a SHA-256 fingerprint into lowercase hex, without colons
+func normalizePin(pin string) (string, error) {
+	pin = strings.ToLower(strings.Replace(strings.TrimSpace(pin), ":", "", -1))
+	if decoded, err := hex.DecodeString(pin); err != nil || len(decoded) != sha256.Size {
+		return "", errors.Errorf("'%s' is not a SHA-256 fingerprint", pin)
+	}
+	return pin, nil
+}
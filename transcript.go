@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Transcripts records the SSH sessions of each controller to a file in a folder
+type Transcripts struct {
+	dir string
+}
+
+// NewTranscripts returns a recorder saving transcripts in the given folder
+func NewTranscripts(dir string) (*Transcripts, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, errors.Wrapf(err, "Failed to create transcripts folder '%s'", dir)
+	}
+	return &Transcripts{dir: dir}, nil
+}
+
+// Open the transcript of a controller. Sessions are appended to the file.
+func (t *Transcripts) Open(md string) (*Transcript, error) {
+	if t == nil {
+		return nil, nil
+	}
+	fname := filepath.Join(t.dir, unsafeFileChars.ReplaceAllString(md, "_")+".transcript")
+	f, err := os.OpenFile(fname, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to open transcript file '%s'", fname)
+	}
+	return &Transcript{file: f}, nil
+}
+
+// Transcript is the timestamped record of the byte stream of SSH sessions.
+// Each line is a timestamp, a direction ("<" received, ">" sent,
+// "#" comment) and the quoted data. A nil Transcript records nothing.
+type Transcript struct {
+	mutex sync.Mutex
+	file  *os.File
+}
+
+func (t *Transcript) record(direction, data string) {
+	if t == nil {
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	fmt.Fprintf(t.file, "%s %s %s\n", time.Now().Format(time.RFC3339Nano), direction, Redact(data))
+}
+
+// Comment adds a note to the transcript, e.g. when a session starts
+func (t *Transcript) Comment(text string) {
+	t.record("#", text)
+}
+
+// Input records data sent to the session. Secrets are redacted.
+func (t *Transcript) Input(data string, secret bool) {
+	if secret {
+		t.record(">", Redacted)
+		return
+	}
+	t.record(">", strconv.Quote(data))
+}
+
+// Output records data received from the session
+func (t *Transcript) Output(data []byte) {
+	t.record("<", strconv.Quote(string(data)))
+}
+
+// Close the transcript
+func (t *Transcript) Close() error {
+	if t == nil {
+		return nil
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.file.Close()
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Version of AOS running in a controller, e.g. 8.2.2.2
+type Version []int
+
+// Matches the version in the output of "show version",
+// e.g. "ArubaOS (MODEL: ArubaMM-VA), Version 8.2.2.2"
+var versionRegexp = regexp.MustCompile(`Version (\d+(\.\d+)+)`)
+
+// ParseVersion parses a dotted version string
+func ParseVersion(text string) (Version, error) {
+	parts := strings.Split(strings.TrimSpace(text), ".")
+	version := make(Version, 0, len(parts))
+	for _, part := range parts {
+		number, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, errors.Errorf("Invalid AOS version '%s'", text)
+		}
+		version = append(version, number)
+	}
+	return version, nil
+}
+
+// String implements fmt.Stringer
+func (v Version) String() string {
+	parts := make([]string, 0, len(v))
+	for _, number := range v {
+		parts = append(parts, strconv.Itoa(number))
+	}
+	return strings.Join(parts, ".")
+}
+
+// versionFromShow extracts the version from the output of "show version"
+func versionFromShow(data interface{}) (Version, error) {
+	lines, err := Select(data, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range lines {
+		if match := versionRegexp.FindStringSubmatch(line); match != nil {
+			return ParseVersion(match[1])
+		}
+	}
+	return nil, errors.New("No version found in the output of 'show version'")
+}
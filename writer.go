@@ -4,11 +4,12 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"strings"
 )
 
 // WriterFactory creates a new writer for every MD
-type WriterFactory func(MD string) (io.WriteCloser, error)
+type WriterFactory func(sw Switch) (io.WriteCloser, error)
 
 type seqFactory struct {
 	sem chan struct{}
@@ -16,16 +17,16 @@ type seqFactory struct {
 
 func newSeqFactory() WriterFactory {
 	sem := make(chan struct{}, 1)
-	return WriterFactory(func(MD string) (io.WriteCloser, error) {
-		label := strings.Join([]string{"*** Controller", MD}, " ")
+	return WriterFactory(func(sw Switch) (io.WriteCloser, error) {
+		label := strings.Join([]string{"*** Controller", sw.Label()}, " ")
 		sem <- struct{}{}
-		fmt.Fprintln(os.Stderr, label)
+		fmt.Fprintln(stderr, label)
 		return seqFactory{sem: sem}, nil
 	})
 }
 
 func (f seqFactory) Write(p []byte) (n int, err error) {
-	return os.Stdout.Write(p)
+	return NewRedactWriter(os.Stdout).Write(p)
 }
 
 func (f seqFactory) Close() error {
@@ -34,11 +35,21 @@ func (f seqFactory) Close() error {
 }
 
 func newFactory(prefix string) WriterFactory {
-	return WriterFactory(func(MD string) (io.WriteCloser, error) {
-		fname := fmt.Sprintf("%s%s.log", prefix, MD)
-		label := strings.Join([]string{"*** Controller", MD, "[ ", fname, " ]"}, " ")
-		fmt.Fprintln(os.Stderr, label)
-		return os.OpenFile(fname, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	return WriterFactory(func(sw Switch) (io.WriteCloser, error) {
+		fname := sw.Expand(prefix)
+		if fname == prefix {
+			// No placeholders, name the file after the IP address
+			fname = fmt.Sprintf("%s%s.log", prefix, sw.IP)
+		} else if err := os.MkdirAll(filepath.Dir(fname), 0755); err != nil {
+			return nil, err
+		}
+		label := strings.Join([]string{"*** Controller", sw.Label(), "[ ", fname, " ]"}, " ")
+		fmt.Fprintln(stderr, label)
+		file, err := os.OpenFile(fname, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, err
+		}
+		return NewRedactCloser(file), nil
 	})
 }
 
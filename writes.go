@@ -0,0 +1,63 @@
+package main
+
+import (
+	"strings"
+)
+
+// WritePolicy decides which operations may change the controllers.
+// By default (nil policy, or not allowed) the run is read-only.
+type WritePolicy struct {
+	allowed bool
+	// Endpoints that may be written (e.g. "object/aaa_user_delete").
+	// If empty, any endpoint may be written.
+	endpoints map[string]bool
+}
+
+// NewWritePolicy returns a policy allowing writes if allowed is true,
+// restricted to the given endpoints if the list is not empty.
+func NewWritePolicy(allowed bool, endpoints []string) *WritePolicy {
+	p := &WritePolicy{allowed: allowed, endpoints: make(map[string]bool, len(endpoints))}
+	for _, endpoint := range endpoints {
+		p.endpoints[normalizeEndpoint(endpoint)] = true
+	}
+	return p
+}
+
+// Allowed returns true if writes are allowed at all
+func (p *WritePolicy) Allowed() bool {
+	return p != nil && p.allowed
+}
+
+// CheckPost returns a ReadOnlyError if the endpoint may not be written
+func (p *WritePolicy) CheckPost(md, endpoint string) error {
+	if !p.Allowed() {
+		return &ReadOnlyError{MD: md, Op: "POST " + endpoint, Reason: "run is read-only, use -allow-write to change the configuration"}
+	}
+	if len(p.endpoints) > 0 && !p.endpoints[normalizeEndpoint(endpoint)] {
+		return &ReadOnlyError{MD: md, Op: "POST " + endpoint, Reason: "endpoint is not in the -write-endpoints list"}
+	}
+	return nil
+}
+
+// CheckCLI returns a ReadOnlyError if the CLI command may change
+// the controller. Only "show" commands are considered read-only.
+func (p *WritePolicy) CheckCLI(md, cmd string) error {
+	if p.Allowed() {
+		return nil
+	}
+	// Several lines would run several commands
+	fields := strings.Fields(cmd)
+	if len(fields) > 0 && strings.ToLower(fields[0]) == "show" && !strings.ContainsAny(cmd, "\r\n") {
+		return nil
+	}
+	return &ReadOnlyError{MD: md, Op: "SSH command '" + cmd + "'", Reason: "run is read-only, only 'show' commands allowed without -allow-write"}
+}
+
+// normalizeEndpoint removes leading slashes and query strings
+func normalizeEndpoint(endpoint string) string {
+	endpoint = strings.TrimPrefix(strings.TrimSpace(endpoint), "/")
+	if index := strings.Index(endpoint, "?"); index >= 0 {
+		endpoint = endpoint[:index]
+	}
+	return endpoint
+}